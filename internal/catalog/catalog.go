@@ -0,0 +1,398 @@
+package catalog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LocationLocal = "local"
+	LocationS3    = "s3"
+)
+
+// ErrNotFound is returned when no backup matches the requested ID
+var ErrNotFound = errors.New("backup not found in catalog")
+
+// errUnchanged tells update that there is nothing to save
+var errUnchanged = errors.New("catalog unchanged")
+
+// Entry describes a single stored backup
+type Entry struct {
+	ID        string    `json:"id"`
+	Job       string    `json:"job"`
+	Cluster   string    `json:"cluster"`
+	Host      string    `json:"host"`
+	Database  string    `json:"database"`
+	Location  string    `json:"location"`
+	Bucket    string    `json:"bucket,omitempty"`
+	Key       string    `json:"key"`
+	Path      string    `json:"path,omitempty"`
+	Size      int64     `json:"size"`
+	Format    string    `json:"format"`
+	CreatedAt time.Time `json:"created_at"`
+	// SHA256 is the hex checksum of the backup file
+	SHA256        string `json:"sha256,omitempty"`
+	ServerVersion string `json:"server_version,omitempty"`
+	// StartLSN, StopLSN and Timeline locate a base backup in the WAL
+	StartLSN string `json:"start_lsn,omitempty"`
+	StopLSN  string `json:"stop_lsn,omitempty"`
+	Timeline int    `json:"timeline,omitempty"`
+	// Pinned backups are not deleted by retention until PinnedUntil, or ever
+	// when it is not set
+	Pinned      bool       `json:"pinned,omitempty"`
+	PinReason   string     `json:"pin_reason,omitempty"`
+	PinnedUntil *time.Time `json:"pinned_until,omitempty"`
+}
+
+// PinActive reports whether the pin still protects the backup at now
+func (e Entry) PinActive(now time.Time) bool {
+	return e.Pinned && (e.PinnedUntil == nil || now.Before(*e.PinnedUntil))
+}
+
+// keepPin copies the pin of the entry a new one replaces, unless the new one is pinned itself
+func (e *Entry) keepPin(old *Entry) {
+	if !e.Pinned && old.Pinned {
+		e.Pinned, e.PinReason, e.PinnedUntil = true, old.PinReason, old.PinnedUntil
+	}
+}
+
+// Filter narrows the result of List, zero fields match everything
+type Filter struct {
+	Job      string
+	Database string
+	Since    time.Time
+	Until    time.Time
+	Query    string
+	// Pinned only matches pinned backups, including expired pins
+	Pinned bool
+}
+
+// Catalog is a small JSON-file database of known backups. The daemon and the
+// CLI commands share the file: changes are made under a file lock on the
+// latest content, and reads pick up changes made by other processes.
+type Catalog struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]*Entry
+	// loaded identifies the file content entries were read from
+	loaded os.FileInfo
+}
+
+// EntryID returns a stable ID for a backup, so a rebuilt catalog keeps the same IDs
+func EntryID(location, key string) string {
+	sum := sha256.Sum256([]byte(location + ":" + key))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Open loads the catalog from path. The returned bool is false when the file
+// didn't exist yet and the catalog starts empty.
+func Open(path string) (*Catalog, bool, error) {
+	c := &Catalog{path: path, entries: make(map[string]*Entry)}
+	if err := c.load(); err != nil {
+		return nil, false, err
+	}
+	return c, c.loaded != nil, nil
+}
+
+// Path returns the file the catalog is stored in
+func (c *Catalog) Path() string {
+	return c.path
+}
+
+// Put adds or replaces an entry and saves the catalog, a replaced entry stays pinned
+func (c *Catalog) Put(e Entry) error {
+	if e.ID == "" {
+		e.ID = EntryID(e.Location, e.Key)
+	}
+
+	return c.update(func() error {
+		if old, ok := c.entries[e.ID]; ok {
+			e.keepPin(old)
+		}
+		c.entries[e.ID] = &e
+		return nil
+	})
+}
+
+// Pin protects the entry with the given ID from retention until until, or
+// forever when it is zero, and saves the catalog
+func (c *Catalog) Pin(id, reason string, until time.Time) (Entry, error) {
+	var pinned Entry
+	err := c.update(func() error {
+		e, ok := c.entries[id]
+		if !ok {
+			return ErrNotFound
+		}
+		e.Pinned, e.PinReason, e.PinnedUntil = true, reason, nil
+		if !until.IsZero() {
+			e.PinnedUntil = &until
+		}
+		pinned = *e
+		return nil
+	})
+	return pinned, err
+}
+
+// Unpin removes the pin of the entry with the given ID and saves the catalog
+func (c *Catalog) Unpin(id string) (Entry, error) {
+	var unpinned Entry
+	err := c.update(func() error {
+		e, ok := c.entries[id]
+		if !ok {
+			return ErrNotFound
+		}
+		e.Pinned, e.PinReason, e.PinnedUntil = false, "", nil
+		unpinned = *e
+		return nil
+	})
+	return unpinned, err
+}
+
+// IsPinned reports whether the backup stored at the given location and key has an active pin
+func (c *Catalog) IsPinned(location, key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refresh()
+
+	e, ok := c.entries[EntryID(location, key)]
+	return ok && e.PinActive(time.Now())
+}
+
+// Remove deletes the entry stored at the given location and key
+func (c *Catalog) Remove(location, key string) error {
+	return c.update(func() error {
+		id := EntryID(location, key)
+		if _, ok := c.entries[id]; !ok {
+			return errUnchanged
+		}
+		delete(c.entries, id)
+		return nil
+	})
+}
+
+// Replace swaps the whole content of the catalog and saves it, backups that
+// were pinned before stay pinned
+func (c *Catalog) Replace(entries []Entry) error {
+	return c.update(func() error {
+		old := c.entries
+		c.entries = make(map[string]*Entry, len(entries))
+		for i := range entries {
+			e := entries[i]
+			if e.ID == "" {
+				e.ID = EntryID(e.Location, e.Key)
+			}
+			if prev, ok := old[e.ID]; ok {
+				e.keepPin(prev)
+			}
+			c.entries[e.ID] = &e
+		}
+		return nil
+	})
+}
+
+// Get returns the entry with the given ID, an unambiguous ID prefix is accepted too
+func (c *Catalog) Get(id string) (Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refresh()
+
+	if e, ok := c.entries[id]; ok {
+		return *e, nil
+	}
+
+	var found *Entry
+	for key, e := range c.entries {
+		if !strings.HasPrefix(key, id) {
+			continue
+		}
+		if found != nil {
+			return Entry{}, fmt.Errorf("backup ID %q is ambiguous", id)
+		}
+		found = e
+	}
+	if found == nil || id == "" {
+		return Entry{}, ErrNotFound
+	}
+	return *found, nil
+}
+
+// List returns entries matching the filter, newest first
+func (c *Catalog) List(f Filter) []Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refresh()
+
+	var result []Entry
+	for _, e := range c.entries {
+		if f.match(e) {
+			result = append(result, *e)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result
+}
+
+// ParseDate parses a filter bound given as YYYY-MM-DD in local time or as RFC 3339,
+// an empty value is the zero time
+func ParseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func (f Filter) match(e *Entry) bool {
+	if f.Job != "" && e.Job != f.Job {
+		return false
+	}
+	if f.Database != "" && e.Database != f.Database {
+		return false
+	}
+	if f.Pinned && !e.Pinned {
+		return false
+	}
+	if !f.Since.IsZero() && e.CreatedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.CreatedAt.Before(f.Until) {
+		return false
+	}
+	if f.Query != "" {
+		query := strings.ToLower(f.Query)
+		fields := []string{e.ID, e.Job, e.Cluster, e.Host, e.Database, e.Key, e.Path}
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), query) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// update runs change on the latest content of the file and saves the result,
+// holding a file lock so that other processes don't overwrite the change
+func (c *Catalog) update(change func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create catalog directory: %w", err)
+	}
+	unlock, err := lockFile(c.path + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock catalog: %w", err)
+	}
+	defer unlock()
+
+	if err := c.load(); err != nil {
+		return err
+	}
+	if err := change(); err != nil {
+		if errors.Is(err, errUnchanged) {
+			return nil
+		}
+		return err
+	}
+	return c.save()
+}
+
+// refresh reloads the catalog when another process saved it since it was
+// read. A file that can't be read keeps the entries known so far, the next
+// change reports the error. The caller must hold c.mu.
+func (c *Catalog) refresh() {
+	info, err := os.Stat(c.path)
+	if err != nil || c.unchanged(info) {
+		return
+	}
+	c.load()
+}
+
+// unchanged reports whether info describes the file the entries were read from,
+// every save replaces the file
+func (c *Catalog) unchanged(info os.FileInfo) bool {
+	return c.loaded != nil && os.SameFile(info, c.loaded) &&
+		info.ModTime().Equal(c.loaded.ModTime()) && info.Size() == c.loaded.Size()
+}
+
+// load replaces the entries with the content of the file, a missing file is
+// an empty catalog. The caller must hold c.mu.
+func (c *Catalog) load() error {
+	file, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		c.entries, c.loaded = make(map[string]*Entry), nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read catalog %s: %w", c.path, err)
+	}
+	defer file.Close()
+
+	// Stat the open file, the path may already point to a newer save
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read catalog %s: %w", c.path, err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("failed to read catalog %s: %w", c.path, err)
+	}
+
+	var entries []*Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse catalog %s: %w", c.path, err)
+	}
+	c.entries = make(map[string]*Entry, len(entries))
+	for _, e := range entries {
+		c.entries[e.ID] = e
+	}
+	c.loaded = info
+	return nil
+}
+
+// save writes the catalog atomically, the caller must hold c.mu and the file lock
+func (c *Catalog) save() error {
+	entries := make([]*Entry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode catalog: %w", err)
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write catalog: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to save catalog: %w", err)
+	}
+	if info, err := os.Stat(c.path); err == nil {
+		c.loaded = info
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2024, 3, 7, 4, 5, 6, 0, time.UTC)
+
+func testEntry(job, key string, age time.Duration) Entry {
+	return Entry{
+		Job:       job,
+		Database:  job,
+		Location:  LocationS3,
+		Key:       key,
+		CreatedAt: testTime.Add(-age),
+	}
+}
+
+func openTest(t *testing.T, path string) *Catalog {
+	t.Helper()
+	c, _, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestOpenMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	c, existed, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existed {
+		t.Error("Open reported a missing catalog as existing")
+	}
+	if err := c.Put(testEntry("app", "a", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, existed, _ := Open(path); !existed {
+		t.Error("Open reported a saved catalog as missing")
+	}
+}
+
+func TestGet(t *testing.T) {
+	c := openTest(t, filepath.Join(t.TempDir(), "catalog.json"))
+	entry := testEntry("app", "app/1.dump", 0)
+	if err := c.Put(entry); err != nil {
+		t.Fatal(err)
+	}
+	id := EntryID(LocationS3, "app/1.dump")
+
+	for _, query := range []string{id, id[:4]} {
+		got, err := c.Get(query)
+		if err != nil || got.Key != entry.Key {
+			t.Errorf("Get(%q) = %+v, %v", query, got, err)
+		}
+	}
+	if _, err := c.Get("zzzz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of an unknown ID = %v, want ErrNotFound", err)
+	}
+	if _, err := c.Get(""); err == nil {
+		t.Error("Get of an empty ID succeeded")
+	}
+}
+
+func TestGetAmbiguous(t *testing.T) {
+	c := openTest(t, filepath.Join(t.TempDir(), "catalog.json"))
+	// With 17 IDs two of them share the first hex digit
+	seen := make(map[string]bool)
+	for i := 0; i < 17; i++ {
+		key := fmt.Sprintf("app/%d.dump", i)
+		if err := c.Put(testEntry("app", key, 0)); err != nil {
+			t.Fatal(err)
+		}
+		prefix := EntryID(LocationS3, key)[:1]
+		if seen[prefix] {
+			if _, err := c.Get(prefix); err == nil || errors.Is(err, ErrNotFound) {
+				t.Errorf("Get(%q) = %v, want an ambiguity error", prefix, err)
+			}
+			return
+		}
+		seen[prefix] = true
+	}
+}
+
+func TestList(t *testing.T) {
+	c := openTest(t, filepath.Join(t.TempDir(), "catalog.json"))
+	entries := []Entry{
+		testEntry("app", "main/app/old.dump", 48*time.Hour),
+		testEntry("app", "main/app/new.dump", 0),
+		testEntry("crm", "main/crm/mid.dump", 24*time.Hour),
+	}
+	if err := c.Replace(entries); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Pin(EntryID(LocationS3, "main/app/old.dump"), "audit", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := func(list []Entry) []string {
+		var result []string
+		for _, e := range list {
+			result = append(result, e.Key)
+		}
+		return result
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "all newest first", want: []string{"main/app/new.dump", "main/crm/mid.dump", "main/app/old.dump"}},
+		{name: "job", filter: Filter{Job: "app"}, want: []string{"main/app/new.dump", "main/app/old.dump"}},
+		{name: "database", filter: Filter{Database: "crm"}, want: []string{"main/crm/mid.dump"}},
+		{name: "since", filter: Filter{Since: testTime.Add(-24 * time.Hour)}, want: []string{"main/app/new.dump", "main/crm/mid.dump"}},
+		{name: "until is exclusive", filter: Filter{Until: testTime.Add(-24 * time.Hour)}, want: []string{"main/app/old.dump"}},
+		{name: "query", filter: Filter{Query: "CRM"}, want: []string{"main/crm/mid.dump"}},
+		{name: "pinned", filter: Filter{Pinned: true}, want: []string{"main/app/old.dump"}},
+	}
+	for _, tt := range tests {
+		got := keys(c.List(tt.filter))
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: List = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPin(t *testing.T) {
+	c := openTest(t, filepath.Join(t.TempDir(), "catalog.json"))
+	entry := testEntry("app", "app/1.dump", 0)
+	if err := c.Put(entry); err != nil {
+		t.Fatal(err)
+	}
+	id := EntryID(LocationS3, entry.Key)
+
+	until := time.Now().Add(time.Hour)
+	pinned, err := c.Pin(id, "incident", until)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pinned.Pinned || pinned.PinReason != "incident" || !pinned.PinnedUntil.Equal(until) {
+		t.Errorf("Pin = %+v", pinned)
+	}
+	if !c.IsPinned(LocationS3, entry.Key) {
+		t.Error("IsPinned = false for a pinned backup")
+	}
+	if pinned.PinActive(until.Add(time.Second)) {
+		t.Error("PinActive = true after the pin expired")
+	}
+
+	// A new entry for the same backup keeps the pin
+	if err := c.Put(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Replace([]Entry{entry}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsPinned(LocationS3, entry.Key) {
+		t.Error("Put or Replace dropped the pin")
+	}
+
+	if _, err := c.Unpin(id); err != nil {
+		t.Fatal(err)
+	}
+	if c.IsPinned(LocationS3, entry.Key) {
+		t.Error("IsPinned = true after Unpin")
+	}
+	if _, err := c.Pin("missing", "", time.Time{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Pin of an unknown ID = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	c := openTest(t, path)
+	if err := c.Put(testEntry("app", "app/1.dump", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Remove(LocationS3, "app/1.dump"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Remove(LocationS3, "app/1.dump"); err != nil {
+		t.Errorf("Remove of a missing entry = %v", err)
+	}
+	if n := len(openTest(t, path).List(Filter{})); n != 0 {
+		t.Errorf("saved catalog has %d entries after Remove, want 0", n)
+	}
+}
+
+// Every process opens its own Catalog on the same file
+func TestSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	daemon := openTest(t, path)
+	entry := testEntry("app", "app/1.dump", 0)
+	if err := daemon.Put(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := openTest(t, path)
+	if _, err := cli.Pin(EntryID(LocationS3, entry.Key), "keep", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !daemon.IsPinned(LocationS3, entry.Key) {
+		t.Error("the daemon doesn't see a pin made by another process")
+	}
+	if err := daemon.Put(testEntry("app", "app/2.dump", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if !openTest(t, path).IsPinned(LocationS3, entry.Key) {
+		t.Error("a later Put of another process dropped the pin")
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	const writers, puts = 4, 10
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		c := openTest(t, path)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < puts; i++ {
+				if err := c.Put(testEntry("app", fmt.Sprintf("app/%d-%d.dump", w, i), 0)); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(openTest(t, path).List(Filter{})); n != writers*puts {
+		t.Errorf("catalog has %d entries, want %d", n, writers*puts)
+	}
+}
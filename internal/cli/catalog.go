@@ -0,0 +1,259 @@
+package cli
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/services/backups"
+	"PostgresDump/pkg/notify"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const catalogUsage = `Usage: pgsnapsafe catalog <command> [flags]
+
+Commands:
+  list                 List backups, newest first
+  show <id>            Show full metadata of a single backup
+  search <text>        List backups whose ID, job, host, database or key contains text
+  rebuild              Recreate the catalog by scanning the backup directory and S3
+
+Flags for list and search:
+  --job <name>         Only backups of this job
+  --db <name>          Only backups of this database
+  --since <date>       Only backups created at or after date (YYYY-MM-DD or RFC3339)
+  --until <date>       Only backups created before date (YYYY-MM-DD or RFC3339)
+  --format table|json  Output format (default table)
+
+Flags for rebuild:
+  --prefix <prefix>    Additional S3 prefix to scan, may be repeated
+  --format table|json  Output format (default table)
+`
+
+// Catalog runs the "catalog" command group and returns the process exit code
+func Catalog(ctx context.Context, cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, catalogUsage)
+		return ExitUsage
+	}
+
+	// A missing catalog is only rebuilt once the arguments are known to be valid
+	switch args[0] {
+	case "rebuild":
+		return catalogRebuild(ctx, cfg, args[1:])
+	case "list":
+		return catalogList(ctx, cfg, args[1:], false)
+	case "search":
+		return catalogList(ctx, cfg, args[1:], true)
+	case "show":
+		return catalogShow(ctx, cfg, args[1:])
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, catalogUsage)
+		return ExitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown catalog command %q\n\n%s", args[0], catalogUsage)
+		return ExitUsage
+	}
+}
+
+func catalogList(ctx context.Context, cfg *config.Config, args []string, search bool) int {
+	fs := flag.NewFlagSet("catalog list", flag.ContinueOnError)
+	job := fs.String("job", "", "only backups of this job")
+	db := fs.String("db", "", "only backups of this database")
+	since := fs.String("since", "", "only backups created at or after this date")
+	until := fs.String("until", "", "only backups created before this date")
+	format := fs.String("format", "table", "output format: table or json")
+
+	args, query := splitQuery(args, search)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if search && query == "" && fs.NArg() > 0 {
+		query = fs.Arg(0)
+	}
+	if search && query == "" {
+		fmt.Fprintln(os.Stderr, "catalog search requires a search text")
+		return ExitUsage
+	}
+
+	filter := catalog.Filter{Job: *job, Database: *db, Query: query}
+	var err error
+	if filter.Since, err = catalog.ParseDate(*since); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --since: %v\n", err)
+		return ExitUsage
+	}
+	if filter.Until, err = catalog.ParseDate(*until); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --until: %v\n", err)
+		return ExitUsage
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return ExitUsage
+	}
+
+	if err := backups.EnsureCatalog(ctx, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error rebuilding catalog: %v\n", err)
+		return ExitFailure
+	}
+	entries := cfg.Catalog.List(filter)
+
+	if *format == "json" {
+		if entries == nil {
+			entries = []catalog.Entry{}
+		}
+		return writeJSON(os.Stdout, entries)
+	}
+	writeEntriesTable(os.Stdout, entries)
+	return ExitOK
+}
+
+func catalogShow(ctx context.Context, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("catalog show", flag.ContinueOnError)
+	format := fs.String("format", "table", "output format: table or json")
+
+	args, id := splitQuery(args, true)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if id == "" && fs.NArg() > 0 {
+		id = fs.Arg(0)
+	}
+	if id == "" {
+		fmt.Fprintln(os.Stderr, "catalog show requires a backup ID")
+		return ExitUsage
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return ExitUsage
+	}
+
+	if err := backups.EnsureCatalog(ctx, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error rebuilding catalog: %v\n", err)
+		return ExitFailure
+	}
+	entry, code := findBackup(cfg, id)
+	if code != ExitOK {
+		return code
+	}
+
+	if *format == "json" {
+		return writeJSON(os.Stdout, entry)
+	}
+	writeEntry(os.Stdout, entry)
+	return ExitOK
+}
+
+func catalogRebuild(ctx context.Context, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("catalog rebuild", flag.ContinueOnError)
+	var prefixes stringList
+	fs.Var(&prefixes, "prefix", "additional S3 prefix to scan")
+	format := fs.String("format", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return ExitUsage
+	}
+
+	result, err := backups.RebuildCatalog(ctx, cfg, prefixes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error rebuilding catalog: %v\n", err)
+		return ExitFailure
+	}
+
+	if *format == "json" {
+		return writeJSON(os.Stdout, result)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Catalog:\t%s\n", cfg.Catalog.Path())
+	fmt.Fprintf(tw, "Backups:\t%d\n", result.Entries)
+	fmt.Fprintf(tw, "From manifests:\t%d\n", result.FromManifest)
+	fmt.Fprintf(tw, "From S3 metadata:\t%d\n", result.FromMetadata)
+	fmt.Fprintf(tw, "From file names:\t%d\n", result.FromName)
+	fmt.Fprintf(tw, "Skipped:\t%d\n", result.Skipped)
+	tw.Flush()
+	return ExitOK
+}
+
+// stringList collects the values of a repeated flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// splitQuery takes a leading positional argument off args, so it may be given before the flags
+func splitQuery(args []string, positional bool) ([]string, string) {
+	if positional && len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		return args[1:], args[0]
+	}
+	return args, ""
+}
+
+func writeJSON(w io.Writer, value any) int {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(value); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error encoding output: %v\n", err)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+func writeEntriesTable(w io.Writer, entries []catalog.Entry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tJOB\tDATABASE\tCREATED\tSIZE\tLOCATION\tKEY")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.ID, e.Job, e.Database, e.CreatedAt.Format("2006-01-02 15:04:05"), notify.FormatSize(e.Size), e.Location, e.Key)
+	}
+	tw.Flush()
+}
+
+func writeEntry(w io.Writer, e catalog.Entry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID:\t%s\n", e.ID)
+	fmt.Fprintf(tw, "Job:\t%s\n", e.Job)
+	fmt.Fprintf(tw, "Cluster:\t%s\n", e.Cluster)
+	fmt.Fprintf(tw, "Host:\t%s\n", e.Host)
+	fmt.Fprintf(tw, "Database:\t%s\n", e.Database)
+	fmt.Fprintf(tw, "Created:\t%s\n", e.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Size:\t%s (%d bytes)\n", notify.FormatSize(e.Size), e.Size)
+	fmt.Fprintf(tw, "Format:\t%s\n", e.Format)
+	if e.SHA256 != "" {
+		fmt.Fprintf(tw, "SHA-256:\t%s\n", e.SHA256)
+	}
+	if e.ServerVersion != "" {
+		fmt.Fprintf(tw, "Server version:\t%s\n", e.ServerVersion)
+	}
+	if e.StartLSN != "" {
+		fmt.Fprintf(tw, "WAL:\t%s to %s on timeline %d\n", e.StartLSN, e.StopLSN, e.Timeline)
+	}
+	fmt.Fprintf(tw, "Location:\t%s\n", e.Location)
+	if e.Bucket != "" {
+		fmt.Fprintf(tw, "Bucket:\t%s\n", e.Bucket)
+	}
+	fmt.Fprintf(tw, "Key:\t%s\n", e.Key)
+	if e.Path != "" {
+		fmt.Fprintf(tw, "Path:\t%s\n", e.Path)
+	}
+	if e.Pinned {
+		fmt.Fprintf(tw, "Pinned until:\t%s\n", pinnedUntil(e))
+		if e.PinReason != "" {
+			fmt.Fprintf(tw, "Pin reason:\t%s\n", e.PinReason)
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"PostgresDump/internal/config"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Exit codes returned by commands
+const (
+	ExitOK       = 0
+	ExitFailure  = 1
+	ExitUsage    = 2
+	ExitNotFound = 3
+)
+
+const usage = `Usage: pgsnapsafe <command> [flags]
+
+Commands:
+  daemon        Run the backup scheduler (default when no command is given)
+  run           Run one backup, cleanup and notification cycle and exit
+  backup now    Create a backup right away
+  restore       Restore a dump with pg_restore
+  list          List backups, same as "catalog list"
+  prune         Delete backups exceeding keep_copies
+  verify        Check that a backup is complete and readable
+  pin           Protect a backup from retention, with --reason and --until
+  unpin         Remove the pin of a backup
+  pinned        List the pinned backups
+  check         Run the preflight checks
+  digest        Send the run digests now
+  catalog       Inspect and rebuild the backup catalog
+
+Run "pgsnapsafe <command> --help" for the flags of a command.
+
+Exit codes: 0 success, 1 failure, 2 usage error, 3 backup or job not found
+`
+
+// command runs with a context that is canceled by SIGINT or SIGTERM
+type command func(ctx context.Context, cfg *config.Config, args []string) int
+
+var commands = map[string]command{
+	"daemon":  daemon,
+	"run":     run,
+	"backup":  backup,
+	"restore": restore,
+	"list":    list,
+	"prune":   prune,
+	"verify":  verify,
+	"pin":     pin,
+	"unpin":   unpin,
+	"pinned":  pinned,
+	"check":   check,
+	"digest":  digest,
+	"catalog": Catalog,
+}
+
+// Run parses the command line, runs the command and returns the process exit code
+func Run(args []string) int {
+	name := "daemon"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	if isHelp(name) {
+		fmt.Fprint(os.Stdout, usage)
+		return ExitOK
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		return ExitUsage
+	}
+
+	// A second signal terminates right away instead of waiting for cleanup
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	cfg := config.Init()
+	return cmd(ctx, cfg, args)
+}
+
+func isHelp(arg string) bool {
+	return arg == "help" || arg == "-h" || arg == "--help"
+}
+
+// selectJobs resolves the --job flags, printing the error for the user
+func selectJobs(cfg *config.Config, names []string) ([]*config.Job, int) {
+	jobs, err := cfg.SelectJobs(names)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return nil, ExitNotFound
+	}
+	return jobs, ExitOK
+}
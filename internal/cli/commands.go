@@ -0,0 +1,258 @@
+package cli
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/processor"
+	"PostgresDump/internal/services/backups"
+	healthcheck "PostgresDump/internal/services/healthCheck"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func backup(ctx context.Context, cfg *config.Config, args []string) int {
+	if len(args) == 0 || args[0] != "now" {
+		fmt.Fprintln(os.Stderr, "Usage: pgsnapsafe backup now [--job <name>]...")
+		return ExitUsage
+	}
+
+	fs := flag.NewFlagSet("backup now", flag.ContinueOnError)
+	var names stringList
+	fs.Var(&names, "job", "job to back up, may be repeated (default all jobs)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return ExitUsage
+	}
+
+	jobs, code := selectJobs(cfg, names)
+	if code != ExitOK {
+		return code
+	}
+
+	code = ExitOK
+	for _, job := range jobs {
+		result, err := processor.Backup(ctx, cfg, job)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", job.Name, err)
+			code = ExitFailure
+			continue
+		}
+		for _, warning := range result.Warnings {
+			fmt.Fprintf(os.Stderr, "⚠️ %s: %s\n", job.Name, warning)
+		}
+		fmt.Fprintf(os.Stdout, "%s\t%s\n", job.Name, result.File)
+	}
+	return code
+}
+
+func restore(ctx context.Context, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	id := fs.String("id", "", "catalog ID of the backup to restore")
+	jobName := fs.String("job", "", "job whose latest backup is restored, also selects the target server")
+	targetDB := fs.String("target-db", "", "database to restore into (default the job database)")
+	clean := fs.Bool("clean", false, "drop database objects before recreating them")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if *id == "" && *jobName == "" {
+		fmt.Fprintln(os.Stderr, "restore requires --id or --job")
+		return ExitUsage
+	}
+
+	if err := backups.EnsureCatalog(ctx, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error rebuilding catalog: %v\n", err)
+		return ExitFailure
+	}
+
+	entry, job, code := resolveBackup(cfg, *id, *jobName)
+	if code != ExitOK {
+		return code
+	}
+
+	err := backups.RestoreBackup(ctx, cfg, job, entry, backups.RestoreOptions{TargetDB: *targetDB, Clean: *clean})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+func verify(ctx context.Context, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	id := fs.String("id", "", "catalog ID of the backup to verify")
+	var names stringList
+	fs.Var(&names, "job", "verify the latest backup of this job, may be repeated (default all jobs)")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if err := backups.EnsureCatalog(ctx, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error rebuilding catalog: %v\n", err)
+		return ExitFailure
+	}
+
+	var entries []catalog.Entry
+	if *id != "" {
+		entry, code := findBackup(cfg, *id)
+		if code != ExitOK {
+			return code
+		}
+		entries = append(entries, entry)
+	} else {
+		jobs, code := selectJobs(cfg, names)
+		if code != ExitOK {
+			return code
+		}
+		for _, job := range jobs {
+			entry, err := backups.LatestBackup(cfg, job)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %s: no backups found\n", job.Name)
+				return ExitNotFound
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	code := ExitOK
+	for _, entry := range entries {
+		if err := processor.Verify(ctx, cfg, entry); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %s (%s): %v\n", entry.ID, entry.Key, err)
+			code = ExitFailure
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "%s\tOK\t%s\n", entry.ID, entry.Key)
+	}
+	return code
+}
+
+func prune(ctx context.Context, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	var names stringList
+	fs.Var(&names, "job", "job to prune, may be repeated (default all jobs)")
+	dryRun := fs.Bool("dry-run", false, "only print the backups that would be deleted")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	jobs, code := selectJobs(cfg, names)
+	if code != ExitOK {
+		return code
+	}
+
+	action := "deleted"
+	if *dryRun {
+		action = "would delete"
+	}
+
+	code = ExitOK
+	for _, job := range jobs {
+		keys, err := backups.PruneBackups(ctx, cfg, job, *dryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", job.Name, err)
+			code = ExitFailure
+		}
+		for _, key := range keys {
+			fmt.Fprintf(os.Stdout, "%s\t%s\t%s\n", job.Name, action, key)
+		}
+	}
+	return code
+}
+
+func list(ctx context.Context, cfg *config.Config, args []string) int {
+	return Catalog(ctx, cfg, append([]string{"list"}, args...))
+}
+
+func check(ctx context.Context, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	format := fs.String("format", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return ExitUsage
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	checks := healthcheck.Preflight(ctx, cfg)
+
+	code := ExitOK
+	for _, c := range checks {
+		if !c.OK {
+			code = ExitFailure
+		}
+	}
+	if *format == "json" {
+		if writeJSON(os.Stdout, checks) != ExitOK {
+			return ExitFailure
+		}
+		return code
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, c := range checks {
+		if c.OK {
+			fmt.Fprintf(tw, "✅\t%s\t%s\n", c.Name, c.Detail)
+		} else {
+			fmt.Fprintf(tw, "❌\t%s\t%s\n", c.Name, c.Error)
+		}
+	}
+	tw.Flush()
+	return code
+}
+
+// resolveBackup finds the backup to work on: the one with the given ID, or the
+// latest one of the named job. The returned job is the one the backup belongs
+// to, unless a job was named explicitly.
+func resolveBackup(cfg *config.Config, id, jobName string) (catalog.Entry, *config.Job, int) {
+	var job *config.Job
+	if jobName != "" {
+		var err error
+		if job, err = cfg.Job(jobName); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return catalog.Entry{}, nil, ExitNotFound
+		}
+	}
+
+	var entry catalog.Entry
+	if id != "" {
+		var code int
+		if entry, code = findBackup(cfg, id); code != ExitOK {
+			return catalog.Entry{}, nil, code
+		}
+	} else {
+		var err error
+		if entry, err = backups.LatestBackup(cfg, job); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %s: no backups found\n", job.Name)
+			return catalog.Entry{}, nil, ExitNotFound
+		}
+	}
+
+	if job == nil {
+		var err error
+		if job, err = cfg.Job(entry.Job); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Backup %s belongs to %v, pass --job to choose the target server\n", entry.ID, err)
+			return catalog.Entry{}, nil, ExitNotFound
+		}
+	}
+	return entry, job, ExitOK
+}
+
+// findBackup looks up a backup by its catalog ID or ID prefix
+func findBackup(cfg *config.Config, id string) (catalog.Entry, int) {
+	entry, err := cfg.Catalog.Get(id)
+	if errors.Is(err, catalog.ErrNotFound) {
+		fmt.Fprintf(os.Stderr, "❌ Backup %q not found\n", id)
+		return catalog.Entry{}, ExitNotFound
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return catalog.Entry{}, ExitUsage
+	}
+	return entry, ExitOK
+}
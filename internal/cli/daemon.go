@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/processor"
+	"PostgresDump/internal/server"
+	"PostgresDump/internal/services/backups"
+	healthcheck "PostgresDump/internal/services/healthCheck"
+	"context"
+	"flag"
+	v "github.com/spf13/viper"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func daemon(ctx context.Context, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	cfg.Log.Info("🚀 Starting backup script...")
+
+	if _, err := os.Stat(cfg.Postgres.BackupPath); os.IsNotExist(err) {
+		err := os.Mkdir(cfg.Postgres.BackupPath, 0755)
+		if err != nil {
+			log.Fatalf("❌ Error creating backup folder: %v", err)
+		}
+	}
+
+	// Files of backups interrupted by a crash or a forced stop
+	if err := backups.RemovePartialFiles(cfg); err != nil {
+		cfg.Log.Warn("⚠️ Error deleting partial files", "error", err)
+	}
+
+	if err := backups.EnsureCatalog(ctx, cfg); err != nil {
+		cfg.Log.Error("❌ Error rebuilding backup catalog", "error", err)
+	}
+
+	// Registered early, SIGUSR1 terminates the process by default
+	triggers := make(chan os.Signal, 1)
+	if len(triggerSignals) > 0 {
+		signal.Notify(triggers, triggerSignals...)
+		defer signal.Stop(triggers)
+	}
+
+	pool := processor.NewPool(ctx, cfg)
+	// The dashboard shows the log lines of every run
+	cfg.Log = pool.CaptureLogs(cfg.Log)
+
+	var srv *server.Server
+	if cfg.HTTP.Listen != "" {
+		processor.InitMetrics(cfg)
+		srv = server.New(cfg, pool)
+		srv.Start()
+	}
+
+	if v.GetBool("health_check") {
+		err := healthcheck.HealthCheck(ctx, cfg)
+		// With the HTTP server /readyz keeps reporting the problem instead
+		if err != nil && srv != nil {
+			cfg.Log.Error("❌ Error checking service health", "error", err)
+		} else if err != nil {
+			log.Fatalf("❌ Error checking service health: %v", err)
+		}
+	}
+
+	// Leadership is kept until the running backups are done
+	if cfg.Leader != nil {
+		cfg.Leader.Start(context.WithoutCancel(ctx))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				cfg.Log.Error("⚠️ Critical error in backup process", "error", r)
+			}
+		}()
+		processor.Run(ctx, cfg, pool)
+	}()
+
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case sig := <-triggers:
+			triggerAll(cfg, pool, sig)
+		}
+	}
+
+	cfg.Log.Info("🛑 Shutting down...")
+	if n := processor.Running(); n > 0 {
+		cfg.Log.Info("⏳ Waiting for running backups to finish, send the signal again to exit immediately",
+			"running", n, "grace", cfg.ShutdownGrace.String())
+	}
+	<-done
+	pool.Close()
+	if cfg.Leader != nil {
+		cfg.Leader.Stop()
+	}
+
+	if srv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			cfg.Log.Error("Error stopping HTTP server", "error", err)
+		}
+	}
+	return ExitOK
+}
+
+// triggerAll queues a run of every job when a trigger signal arrives
+func triggerAll(cfg *config.Config, pool *processor.Pool, sig os.Signal) {
+	for _, job := range cfg.Jobs {
+		status, err := pool.Submit(job, processor.TriggerSignal, nil)
+		if err != nil {
+			cfg.Log.Warn("⏭️ On-demand backup not queued", "job", job.Name, "signal", sig.String(), "error", err)
+			continue
+		}
+		cfg.Log.Info("▶️ On-demand backup queued", "job", job.Name, "run", status.ID, "signal", sig.String())
+	}
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/processor"
+	"PostgresDump/pkg/notify"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// digest sends the configured digests right away, or prints them with --dry-run
+func digest(ctx context.Context, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("digest", flag.ContinueOnError)
+	var names stringList
+	fs.Var(&names, "name", "digest to send, may be repeated (default all digests)")
+	dryRun := fs.Bool("dry-run", false, "print the digest instead of sending it")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	digests := cfg.Digests
+	if len(names) > 0 {
+		digests = nil
+		for _, name := range names {
+			d, err := cfg.Digest(name)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+				return ExitNotFound
+			}
+			digests = append(digests, d)
+		}
+	}
+	if len(digests) == 0 {
+		fmt.Fprintln(os.Stderr, "❌ no digest channels configured in notifications.channels")
+		return ExitNotFound
+	}
+
+	now := time.Now()
+	code := ExitOK
+	for _, d := range digests {
+		if *dryRun {
+			fmt.Fprintln(os.Stdout, notify.DigestText(processor.BuildDigest(cfg, d, now)))
+			continue
+		}
+		if err := processor.SendDigest(ctx, cfg, d, now); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %s: %v\n", d.Name, err)
+			code = ExitFailure
+		}
+	}
+	return code
+}
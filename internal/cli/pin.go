@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/services/backups"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// pin protects a backup from retention, "pin <id> --reason text --until date"
+func pin(ctx context.Context, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("pin", flag.ContinueOnError)
+	reason := fs.String("reason", "", "why the backup is kept, shown by pinned")
+	until := fs.String("until", "", "keep the backup until this date (YYYY-MM-DD or RFC3339, default forever)")
+
+	args, id := splitQuery(args, true)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if id == "" && fs.NArg() > 0 {
+		id = fs.Arg(0)
+	}
+	if id == "" {
+		fmt.Fprintln(os.Stderr, "pin requires a backup ID")
+		return ExitUsage
+	}
+	expires, err := catalog.ParseDate(*until)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --until: %v\n", err)
+		return ExitUsage
+	}
+	if !expires.IsZero() && !expires.After(time.Now()) {
+		fmt.Fprintln(os.Stderr, "--until must be in the future")
+		return ExitUsage
+	}
+
+	if err := backups.EnsureCatalog(ctx, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error rebuilding catalog: %v\n", err)
+		return ExitFailure
+	}
+	entry, code := findBackup(cfg, id)
+	if code != ExitOK {
+		return code
+	}
+	pinnedEntry, err := backups.PinBackup(ctx, cfg, entry, *reason, expires)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error pinning %s: %v\n", entry.ID, err)
+		return ExitFailure
+	}
+	fmt.Fprintf(os.Stdout, "%s\tpinned until %s\t%s\n", pinnedEntry.ID, pinnedUntil(pinnedEntry), pinnedEntry.Key)
+	return ExitOK
+}
+
+// unpin removes the pin of a backup, "unpin <id>"
+func unpin(ctx context.Context, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("unpin", flag.ContinueOnError)
+	args, id := splitQuery(args, true)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if id == "" && fs.NArg() > 0 {
+		id = fs.Arg(0)
+	}
+	if id == "" {
+		fmt.Fprintln(os.Stderr, "unpin requires a backup ID")
+		return ExitUsage
+	}
+
+	if err := backups.EnsureCatalog(ctx, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error rebuilding catalog: %v\n", err)
+		return ExitFailure
+	}
+	entry, code := findBackup(cfg, id)
+	if code != ExitOK {
+		return code
+	}
+	if _, err := backups.UnpinBackup(ctx, cfg, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error unpinning %s: %v\n", entry.ID, err)
+		return ExitFailure
+	}
+	fmt.Fprintf(os.Stdout, "%s\tunpinned\t%s\n", entry.ID, entry.Key)
+	return ExitOK
+}
+
+// pinned lists the pinned backups, expired pins included
+func pinned(ctx context.Context, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("pinned", flag.ContinueOnError)
+	job := fs.String("job", "", "only backups of this job")
+	format := fs.String("format", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if err := backups.EnsureCatalog(ctx, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error rebuilding catalog: %v\n", err)
+		return ExitFailure
+	}
+	entries := cfg.Catalog.List(catalog.Filter{Job: *job, Pinned: true})
+
+	switch *format {
+	case "json":
+		if entries == nil {
+			entries = []catalog.Entry{}
+		}
+		return writeJSON(os.Stdout, entries)
+	case "table":
+		writePinnedTable(os.Stdout, entries)
+		return ExitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return ExitUsage
+	}
+}
+
+func writePinnedTable(w io.Writer, entries []catalog.Entry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tJOB\tCREATED\tLOCATION\tUNTIL\tREASON\tKEY")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.ID, e.Job, e.CreatedAt.Format("2006-01-02 15:04:05"), e.Location, pinnedUntil(e), e.PinReason, e.Key)
+	}
+	tw.Flush()
+}
+
+// pinnedUntil describes how long a pin lasts
+func pinnedUntil(e catalog.Entry) string {
+	switch {
+	case e.PinnedUntil == nil:
+		return "forever"
+	case !e.PinActive(time.Now()):
+		return e.PinnedUntil.Format("2006-01-02 15:04:05") + " (expired)"
+	default:
+		return e.PinnedUntil.Format("2006-01-02 15:04:05")
+	}
+}
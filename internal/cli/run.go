@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/processor"
+	"PostgresDump/internal/services/backups"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// run performs one backup, cleanup and notification cycle and exits, for use
+// with Kubernetes CronJobs and systemd timers instead of the internal scheduler.
+// Like the daemon, it runs jobs concurrently within the concurrency limits and
+// gives running backups shutdown_grace_period to finish.
+func run(ctx context.Context, cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	var names stringList
+	fs.Var(&names, "job", "job to run, may be repeated (default all jobs)")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	jobs, code := selectJobs(cfg, names)
+	if code != ExitOK {
+		return code
+	}
+
+	if err := backups.EnsureCatalog(ctx, cfg); err != nil {
+		cfg.Log.Error("❌ Error rebuilding backup catalog", "error", err)
+	}
+
+	// The jobs run concurrently within the concurrency limits
+	results := make([]*processor.JobResult, len(jobs))
+	pool := processor.NewPool(ctx, cfg)
+	for i, job := range jobs {
+		pool.Submit(job, processor.TriggerCLI, func(r processor.JobResult) { results[i] = &r })
+	}
+	pool.Close()
+
+	code = ExitOK
+	for i, job := range jobs {
+		result := results[i]
+		if result == nil {
+			fmt.Fprintf(os.Stderr, "%s: skipped\n", job.Name)
+			code = ExitFailure
+			continue
+		}
+		if err := result.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", job.Name, err)
+			code = ExitFailure
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "%s\t%s\n", job.Name, result.File)
+	}
+	return code
+}
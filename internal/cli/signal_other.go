@@ -0,0 +1,8 @@
+//go:build !unix
+
+package cli
+
+import "os"
+
+// triggerSignals queue a run of every job in the daemon, there is no SIGUSR1 here
+var triggerSignals []os.Signal
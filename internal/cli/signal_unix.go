@@ -0,0 +1,11 @@
+//go:build unix
+
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+// triggerSignals queue a run of every job in the daemon
+var triggerSignals = []os.Signal{syscall.SIGUSR1}
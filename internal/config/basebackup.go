@@ -0,0 +1,91 @@
+package config
+
+import (
+	"PostgresDump/pkg/keytpl"
+	"fmt"
+	v "github.com/spf13/viper"
+	"strings"
+)
+
+// Types of backup a job creates
+const (
+	// BackupLogical dumps a single database with pg_dump
+	BackupLogical = "logical"
+	// BackupPhysical copies the whole cluster with pg_basebackup
+	BackupPhysical = "physical"
+)
+
+// defaultBaseBackupCompression keeps base backups small without extra packages
+const defaultBaseBackupCompression = "gzip"
+
+// BaseBackup holds the pg_basebackup settings of physical jobs
+type BaseBackup struct {
+	// Compression is none or a --compress method of pg_basebackup: gzip, lz4 or
+	// zstd, optionally prefixed with client- or server- and followed by :level
+	Compression string `mapstructure:"compression"`
+	// Checkpoint is fast or spread, the server default
+	Checkpoint string `mapstructure:"checkpoint"`
+}
+
+// override returns the settings with the ones set in o replaced
+func (b BaseBackup) override(o BaseBackup) BaseBackup {
+	if o.Compression != "" {
+		b.Compression = o.Compression
+	}
+	if o.Checkpoint != "" {
+		b.Checkpoint = o.Checkpoint
+	}
+	return b
+}
+
+// complete fills in the default compression and validates the settings
+func (b *BaseBackup) complete() error {
+	if b.Compression == "" {
+		b.Compression = defaultBaseBackupCompression
+	}
+	method, _, _ := strings.Cut(b.Compression, ":")
+	method = strings.TrimPrefix(strings.TrimPrefix(method, "client-"), "server-")
+	switch method {
+	case "none", "gzip", "lz4", "zstd":
+	default:
+		return fmt.Errorf("basebackup.compression: unknown method %q, expected none, gzip, lz4 or zstd", b.Compression)
+	}
+	if b.Checkpoint != "" && b.Checkpoint != "fast" && b.Checkpoint != "spread" {
+		return fmt.Errorf("basebackup.checkpoint must be fast or spread")
+	}
+	return nil
+}
+
+// completeType validates the backup type of a job, logical when not set
+func (b *BackupConfig) completeType() error {
+	switch b.Type {
+	case "":
+		b.Type = BackupLogical
+	case BackupLogical, BackupPhysical:
+	default:
+		return fmt.Errorf("type must be %s or %s", BackupLogical, BackupPhysical)
+	}
+	if b.Type != BackupPhysical {
+		return nil
+	}
+	return b.BaseBackup.complete()
+}
+
+// physicalKey switches a physical job to the base backup template unless the
+// job entry sets its own key_template, jobKey. The key_template of the backup
+// section only applies to physical jobs when the section is physical itself,
+// so logical and physical backups of a database don't share keys.
+func (b *BackupConfig) physicalKey(jobKey string) error {
+	if b.Type != BackupPhysical || jobKey != "" {
+		return nil
+	}
+	if v.IsSet("backup.key_template") && v.GetString("backup.type") == BackupPhysical {
+		return nil
+	}
+	key, err := keytpl.Parse(keytpl.DefaultPhysicalTemplate)
+	if err != nil {
+		return err
+	}
+	b.KeyTemplate, b.Key = keytpl.DefaultPhysicalTemplate, key
+	return nil
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	v "github.com/spf13/viper"
+	"log"
+)
+
+// Concurrency limits how many backups run at the same time
+type Concurrency struct {
+	// MaxJobs is the number of backups running at once, 1 when not set
+	MaxJobs int `mapstructure:"max_jobs"`
+	// PerHost is the number of backups running at once against one PostgreSQL
+	// server (host and port), only MaxJobs applies when not set
+	PerHost int `mapstructure:"per_host"`
+}
+
+// loadConcurrency reads the concurrency section
+func loadConcurrency() Concurrency {
+	var c Concurrency
+	if err := v.UnmarshalKey("concurrency", &c); err != nil {
+		log.Fatalf("❌ Error processing concurrency: %v", err)
+	}
+	if c.MaxJobs < 0 || c.PerHost < 0 {
+		log.Fatalf("❌ Error: concurrency.max_jobs and concurrency.per_host must not be negative")
+	}
+	if c.MaxJobs == 0 {
+		c.MaxJobs = 1
+	}
+	return c
+}
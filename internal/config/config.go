@@ -1,7 +1,13 @@
 package config
 
 import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/history"
 	"PostgresDump/pkg/email"
+	"PostgresDump/pkg/keytpl"
+	"PostgresDump/pkg/leader"
+	"PostgresDump/pkg/notify"
+	"PostgresDump/pkg/pgbin"
 	"PostgresDump/pkg/slogger"
 	"PostgresDump/pkg/stree"
 	"fmt"
@@ -9,22 +15,56 @@ import (
 	v "github.com/spf13/viper"
 	"log"
 	"log/slog"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	Log        *slog.Logger
 	Postgres   *Postgres
-	Backup     *BackupConfig
+	Jobs       []*Job
 	S3Client   *s3.Client
 	BucketName string
 	SMTPClient *email.SMTPClient
+	// EmailRecipients receive the reports of the email channel
+	EmailRecipients email.Recipients
+	Notifier        *notify.Dispatcher
+	Digests         []*Digest
+	History         *history.Store
+	Catalog         *catalog.Catalog
+	// CatalogCreated is true when the catalog file didn't exist and has to be rebuilt from storage
+	CatalogCreated bool
+	// DownloadLinkTTL is how long presigned S3 download links in notifications stay valid, 0 disables them
+	DownloadLinkTTL time.Duration
+	HTTP            HTTP
+	Concurrency     Concurrency
+	// Leader decides which replica runs each job, nil without leader election
+	Leader *leader.Elector
+	// ShutdownGrace is how long a backup in progress may run after a stop signal before it is aborted
+	ShutdownGrace time.Duration
+	// PGBinDirs are glob patterns searched for versioned pg_dump and pg_restore binaries
+	PGBinDirs []string
 }
 
 type BackupConfig struct {
-	Times      []string `mapstructure:"times"`
-	KeepCopies int      `mapstructure:"keep_copies"`
-	HeathCheck bool     `bool:"health_check"`
+	Job         string           `mapstructure:"job"`
+	Times       []string         `mapstructure:"times"`
+	KeepCopies  int              `mapstructure:"keep_copies"`
+	HeathCheck  bool             `bool:"health_check"`
+	Cluster     string           `mapstructure:"cluster"`
+	KeyTemplate string           `mapstructure:"key_template"`
+	Key         *keytpl.Template `mapstructure:"-"`
+	// MaxAge is the oldest the newest backup may be before /readyz fails, 0 disables the check
+	MaxAge time.Duration `mapstructure:"max_age"`
+	// Phases holds the timeout and retries of the dump, upload and notify phases
+	Phases Phases `mapstructure:"phases"`
+	// Hooks run before and after every backup
+	Hooks Hooks `mapstructure:"hooks"`
+	// Type is logical (pg_dump) or physical (pg_basebackup)
+	Type       string     `mapstructure:"type"`
+	BaseBackup BaseBackup `mapstructure:"basebackup"`
 }
 
 type Postgres struct {
@@ -36,6 +76,12 @@ type Postgres struct {
 	BackupPath string
 }
 
+// DSN returns the connection string for database/sql
+func (p *Postgres) DSN() string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		p.Host, p.Port, p.User, p.Password, p.Dbname)
+}
+
 func (p *Postgres) new() *Postgres {
 	return &Postgres{
 		Host:       v.GetString("POSTGRESQL_HOST"),
@@ -52,12 +98,6 @@ func Init() *Config {
 	cfg.Log = initLogger()
 
 	requiredVars := []string{
-		"POSTGRESQL_HOST",
-		"POSTGRESQL_PORT",
-		"POSTGRESQL_USER",
-		"POSTGRESQL_PASSWORD",
-		"POSTGRESQL_DBNAME",
-
 		"DIRECTORY_BACKUP_PATH",
 	}
 
@@ -70,6 +110,19 @@ func Init() *Config {
 
 	v.AutomaticEnv()
 
+	backupDefaults := loadConfigBackup()
+
+	// Without a jobs section the single job is described by the POSTGRESQL_* variables
+	if !v.IsSet("jobs") {
+		requiredVars = append(requiredVars,
+			"POSTGRESQL_HOST",
+			"POSTGRESQL_PORT",
+			"POSTGRESQL_USER",
+			"POSTGRESQL_PASSWORD",
+			"POSTGRESQL_DBNAME",
+		)
+	}
+
 	if err := checkEnv(requiredVars); err != nil {
 		log.Fatalf("Error checking environment variables: %v", err)
 	}
@@ -85,14 +138,33 @@ func Init() *Config {
 
 	cfg.Postgres = cfg.Postgres.new()
 	cfg.BucketName = v.GetString("S3_BUCKET_NAME")
-	cfg.Backup = loadConfigBackup()
+	cfg.Jobs = loadJobs(backupDefaults, cfg.Postgres)
 
 	if !v.GetBool("s3") {
 		cfg.S3Client = nil
 	}
+	cfg.DownloadLinkTTL = loadDownloadLinkTTL()
+
+	cfg.Catalog, cfg.CatalogCreated = openCatalog(cfg.Postgres.BackupPath)
+	cfg.History = openHistory(cfg.Postgres.BackupPath)
 	if !v.GetBool("smtp") {
 		cfg.SMTPClient = nil
 	}
+	if cfg.SMTPClient != nil {
+		if err := email.Validate(); err != nil {
+			log.Fatalf("❌ Error in email templates: %v", err)
+		}
+		cfg.EmailRecipients = loadEmailRecipients()
+	}
+	cfg.Notifier = loadNotifier(&cfg)
+	cfg.HTTP = loadHTTP()
+	cfg.Concurrency = loadConcurrency()
+	cfg.ShutdownGrace = loadShutdownGrace()
+	cfg.Leader = loadLeaderElection(&cfg)
+	cfg.PGBinDirs = pgbin.DefaultDirs
+	if v.IsSet("pg_bin_dirs") {
+		cfg.PGBinDirs = v.GetStringSlice("pg_bin_dirs")
+	}
 
 	cfg.Log.Info("Environment initialization completed. ✅")
 	return &cfg
@@ -128,12 +200,53 @@ func initSMTPClient() *email.SMTPClient {
 		v.GetString("SMTP_PASS"),
 		v.GetString("SMTP_SENDER_SIGN"),
 	)
+	client.From = v.GetString("SMTP_FROM")
+	if tlsMode := v.GetString("SMTP_TLS"); tlsMode != "" {
+		client.TLS = strings.ToLower(tlsMode)
+	}
+	if auth := v.GetString("SMTP_AUTH"); auth != "" {
+		client.Auth = strings.ToLower(auth)
+	}
+	if caFile := v.GetString("SMTP_CA_FILE"); caFile != "" {
+		if err := client.LoadCAFile(caFile); err != nil {
+			log.Fatalf("❌ Error in SMTP_CA_FILE: %v", err)
+		}
+	}
+	if v.GetString("SMTP_TIMEOUT") != "" {
+		client.Timeout = parsePositiveDuration("SMTP_TIMEOUT")
+	}
+	if err := client.Validate(); err != nil {
+		log.Fatalf("❌ Error in SMTP settings: %v", err)
+	}
 	return client
 }
 
+// loadEmailRecipients reads email_delivery, email_cc and email_bcc, each a
+// list or a comma-separated string
+func loadEmailRecipients() email.Recipients {
+	r := email.Recipients{
+		To:  email.ParseAddresses(v.GetStringSlice("email_delivery")),
+		Cc:  email.ParseAddresses(v.GetStringSlice("email_cc")),
+		Bcc: email.ParseAddresses(v.GetStringSlice("email_bcc")),
+	}
+	if len(r.All()) == 0 {
+		log.Fatalf("❌ Error: email_delivery is required when smtp is enabled")
+	}
+	return r
+}
+
+func parsePositiveDuration(key string) time.Duration {
+	d, err := time.ParseDuration(v.GetString(key))
+	if err != nil || d <= 0 {
+		log.Fatalf("❌ Error in %s: expected a positive duration such as 30s", key)
+	}
+	return d
+}
+
 func loadConfigBackup() *BackupConfig {
+	// Merge instead of read, so values from .env stay available
 	v.SetConfigFile("config-example.yml")
-	err := v.ReadInConfig()
+	err := v.MergeInConfig()
 	if err != nil {
 		log.Fatalf("❌ Error reading config-example.yml: %v", err)
 	}
@@ -149,10 +262,70 @@ func loadConfigBackup() *BackupConfig {
 		log.Fatalf("❌ Error processing config: %v", err)
 	}
 
-	log.Printf("📌 Loaded backup settings: %+v\n", cfg)
+	if cfg.KeyTemplate == "" {
+		cfg.KeyTemplate = keytpl.DefaultTemplate
+	}
+	cfg.Key, err = keytpl.Parse(cfg.KeyTemplate)
+	if err != nil {
+		log.Fatalf("❌ Error in backup.key_template: %v", err)
+	}
+
 	return &cfg
 }
 
+func openCatalog(backupPath string) (*catalog.Catalog, bool) {
+	path := v.GetString("catalog_path")
+	if path == "" {
+		path = filepath.Join(backupPath, "catalog.json")
+	}
+
+	c, existed, err := catalog.Open(path)
+	if err != nil {
+		log.Fatalf("❌ Error opening backup catalog: %v", err)
+	}
+	return c, !existed
+}
+
+// loadDownloadLinkTTL reads download_link_ttl, S3 presigned URLs are valid for at most 7 days
+func loadDownloadLinkTTL() time.Duration {
+	if !v.IsSet("download_link_ttl") {
+		return 72 * time.Hour
+	}
+	ttl, err := time.ParseDuration(v.GetString("download_link_ttl"))
+	if err != nil {
+		log.Fatalf("❌ Error: invalid download_link_ttl: %v", err)
+	}
+	if ttl > 7*24*time.Hour {
+		log.Fatalf("❌ Error: download_link_ttl can't be longer than 168h")
+	}
+	return ttl
+}
+
+// loadShutdownGrace reads shutdown_grace_period, 0 aborts running backups immediately
+func loadShutdownGrace() time.Duration {
+	if v.GetString("shutdown_grace_period") == "" {
+		return 5 * time.Minute
+	}
+	grace, err := time.ParseDuration(v.GetString("shutdown_grace_period"))
+	if err != nil || grace < 0 {
+		log.Fatalf("❌ Error: invalid shutdown_grace_period %q", v.GetString("shutdown_grace_period"))
+	}
+	return grace
+}
+
+func openHistory(backupPath string) *history.Store {
+	path := v.GetString("history_path")
+	if path == "" {
+		path = filepath.Join(backupPath, "history.json")
+	}
+
+	h, err := history.Open(path)
+	if err != nil {
+		log.Fatalf("❌ Error opening run history: %v", err)
+	}
+	return h
+}
+
 func checkEnv(requiredVars []string) error {
 	var missingVars []string
 
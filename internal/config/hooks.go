@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Stages at which hooks run
+const (
+	HookPre       = "pre"
+	HookPost      = "post"
+	HookOnFailure = "on_failure"
+)
+
+// What a failing hook does to the run
+const (
+	HookAbort = "abort"
+	HookWarn  = "warn"
+)
+
+// defaultHookTimeout bounds a hook without a timeout, so it can't stall the job
+const defaultHookTimeout = time.Minute
+
+// Hook is a shell command or an SQL statement run around a backup
+type Hook struct {
+	Name string `mapstructure:"name"`
+	// Command runs with sh -c, or cmd /C on Windows
+	Command string `mapstructure:"command"`
+	// SQL runs on the job's database
+	SQL     string        `mapstructure:"sql"`
+	Timeout time.Duration `mapstructure:"timeout"`
+	// OnError is abort or warn, pre hooks abort and the others warn by default
+	OnError string `mapstructure:"on_error"`
+	// Env holds additional NAME=value variables of a command
+	Env []string `mapstructure:"env"`
+}
+
+// Hooks run before a backup, after a successful one and after a failed one
+type Hooks struct {
+	Pre       []Hook `mapstructure:"pre"`
+	Post      []Hook `mapstructure:"post"`
+	OnFailure []Hook `mapstructure:"on_failure"`
+}
+
+// Stage returns the hooks of the given stage
+func (h Hooks) Stage(stage string) []Hook {
+	switch stage {
+	case HookPre:
+		return h.Pre
+	case HookPost:
+		return h.Post
+	case HookOnFailure:
+		return h.OnFailure
+	}
+	return nil
+}
+
+// override returns the hooks with the stages set in o replaced
+func (h Hooks) override(o Hooks) Hooks {
+	if len(o.Pre) > 0 {
+		h.Pre = o.Pre
+	}
+	if len(o.Post) > 0 {
+		h.Post = o.Post
+	}
+	if len(o.OnFailure) > 0 {
+		h.OnFailure = o.OnFailure
+	}
+	return h
+}
+
+// complete fills in names, timeouts and on_error of every hook and validates them
+func (h *Hooks) complete() error {
+	var err error
+	if h.Pre, err = completeHooks(HookPre, h.Pre, HookAbort); err != nil {
+		return err
+	}
+	if h.Post, err = completeHooks(HookPost, h.Post, HookWarn); err != nil {
+		return err
+	}
+	h.OnFailure, err = completeHooks(HookOnFailure, h.OnFailure, HookWarn)
+	return err
+}
+
+func completeHooks(stage string, hooks []Hook, onError string) ([]Hook, error) {
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+	completed := make([]Hook, len(hooks))
+	for i, hook := range hooks {
+		field := fmt.Sprintf("hooks.%s[%d]", stage, i)
+		if (hook.Command == "") == (hook.SQL == "") {
+			return nil, fmt.Errorf("%s: set either command or sql", field)
+		}
+		if hook.Name == "" {
+			hook.Name = fmt.Sprintf("%s[%d]", stage, i)
+		}
+		if hook.Timeout < 0 {
+			return nil, fmt.Errorf("%s: timeout must not be negative", field)
+		}
+		if hook.Timeout == 0 {
+			hook.Timeout = defaultHookTimeout
+		}
+		if hook.OnError == "" {
+			hook.OnError = onError
+		}
+		switch {
+		case hook.OnError != HookAbort && hook.OnError != HookWarn:
+			return nil, fmt.Errorf("%s: on_error must be abort or warn", field)
+		case hook.OnError == HookAbort && stage == HookOnFailure:
+			return nil, fmt.Errorf("%s: on_failure hooks can only warn", field)
+		}
+		for _, env := range hook.Env {
+			if name, _, ok := strings.Cut(env, "="); !ok || name == "" {
+				return nil, fmt.Errorf("%s: env entry %q is not NAME=value", field, env)
+			}
+		}
+		if hook.SQL != "" && len(hook.Env) > 0 {
+			return nil, fmt.Errorf("%s: env only applies to commands", field)
+		}
+		completed[i] = hook
+	}
+	return completed, nil
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	v "github.com/spf13/viper"
+	"log"
+)
+
+// HTTP configures the HTTP server of the daemon
+type HTTP struct {
+	// Listen is the address the server listens on, the server is disabled when empty
+	Listen string `mapstructure:"listen"`
+	// APIToken is the bearer token of the /api endpoints with the admin scope:
+	// it may also trigger runs, pin and delete backups
+	APIToken string `mapstructure:"api_token"`
+	// APIReadToken is the bearer token with the read-only scope, the API is
+	// disabled when neither token is set
+	APIReadToken string `mapstructure:"api_read_token"`
+}
+
+// loadHTTP reads the http section, HTTP_LISTEN, HTTP_API_TOKEN and
+// HTTP_API_READ_TOKEN override the matching keys
+func loadHTTP() HTTP {
+	var h HTTP
+	if err := v.UnmarshalKey("http", &h); err != nil {
+		log.Fatalf("❌ Error processing http: %v", err)
+	}
+	if listen := v.GetString("HTTP_LISTEN"); listen != "" {
+		h.Listen = listen
+	}
+	if token := v.GetString("HTTP_API_TOKEN"); token != "" {
+		h.APIToken = token
+	}
+	if token := v.GetString("HTTP_API_READ_TOKEN"); token != "" {
+		h.APIReadToken = token
+	}
+	if h.APIToken != "" && h.APIToken == h.APIReadToken {
+		log.Fatalf("❌ Error: http.api_token and http.api_read_token must differ")
+	}
+	return h
+}
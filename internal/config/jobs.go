@@ -0,0 +1,250 @@
+package config
+
+import (
+	"PostgresDump/pkg/keytpl"
+	"PostgresDump/pkg/notify"
+	"fmt"
+	v "github.com/spf13/viper"
+	"log"
+	"net"
+	"time"
+)
+
+// Job is a single database backed up on its own schedule
+type Job struct {
+	Name     string
+	Postgres *Postgres
+	Backup   *BackupConfig
+	// Routes override notifications.routes when set
+	Routes []notify.Route
+}
+
+// jobConfig is an entry of the jobs section, empty fields fall back to the
+// backup section and the POSTGRESQL_* variables
+type jobConfig struct {
+	Name        string         `mapstructure:"name"`
+	Host        string         `mapstructure:"host"`
+	Port        string         `mapstructure:"port"`
+	User        string         `mapstructure:"user"`
+	PasswordEnv string         `mapstructure:"password_env"`
+	Dbname      string         `mapstructure:"dbname"`
+	Times       []string       `mapstructure:"times"`
+	KeepCopies  *int           `mapstructure:"keep_copies"`
+	Cluster     string         `mapstructure:"cluster"`
+	KeyTemplate string         `mapstructure:"key_template"`
+	MaxAge      *time.Duration `mapstructure:"max_age"`
+	Notify      []notify.Route `mapstructure:"notify"`
+	// Phases replace the phases of the backup section they set
+	Phases Phases `mapstructure:"phases"`
+	// Hooks replace the hook stages of the backup section they set
+	Hooks Hooks `mapstructure:"hooks"`
+	// Type is logical or physical, the type of the backup section when empty
+	Type string `mapstructure:"type"`
+	// BaseBackup replaces the pg_basebackup settings of the backup section it sets
+	BaseBackup BaseBackup `mapstructure:"basebackup"`
+}
+
+// Job returns the job with the given name
+func (c *Config) Job(name string) (*Job, error) {
+	for _, job := range c.Jobs {
+		if job.Name == name {
+			return job, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown job %q", name)
+}
+
+// SelectJobs returns the jobs with the given names, or every job when no names are given
+func (c *Config) SelectJobs(names []string) ([]*Job, error) {
+	if len(names) == 0 {
+		return c.Jobs, nil
+	}
+	var jobs []*Job
+	for _, name := range names {
+		job, err := c.Job(name)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Server identifies the job's PostgreSQL server for the per-host concurrency limit
+func (j *Job) Server() string {
+	return net.JoinHostPort(j.Postgres.Host, j.Postgres.Port)
+}
+
+// KeyVars returns the values used to render the backup key template at the given time
+func (j *Job) KeyVars(t time.Time) keytpl.Vars {
+	return keytpl.Vars{
+		Job:      j.Name,
+		Cluster:  j.Backup.Cluster,
+		Host:     j.Postgres.Host,
+		Database: j.Postgres.Dbname,
+		Time:     t,
+	}
+}
+
+func loadJobs(defaults *BackupConfig, pg *Postgres) []*Job {
+	if !v.IsSet("jobs") {
+		backup := *defaults
+		if backup.Job == "" {
+			backup.Job = pg.Dbname
+		}
+		if backup.Cluster == "" {
+			backup.Cluster = pg.Host
+		}
+		if err := backup.Phases.complete(); err != nil {
+			log.Fatalf("❌ Error in backup.%v", err)
+		}
+		if err := backup.Hooks.complete(); err != nil {
+			log.Fatalf("❌ Error in backup.%v", err)
+		}
+		if err := backup.completeType(); err != nil {
+			log.Fatalf("❌ Error in backup.%v", err)
+		}
+		if err := backup.physicalKey(""); err != nil {
+			log.Fatalf("❌ Error in backup.%v", err)
+		}
+		job := &Job{Name: backup.Job, Postgres: pg, Backup: &backup}
+		if err := backup.Key.Check(job.KeyVars(time.Time{})); err != nil {
+			log.Fatalf("❌ Error in backup.key_template: %v", err)
+		}
+		log.Printf("📌 Loaded backup settings: %+v\n", backup)
+		return []*Job{job}
+	}
+
+	var configs []jobConfig
+	if err := v.UnmarshalKey("jobs", &configs); err != nil {
+		log.Fatalf("❌ Error processing jobs: %v", err)
+	}
+	if len(configs) == 0 {
+		log.Fatalf("❌ Error: 'jobs' section is empty")
+	}
+
+	names := make(map[string]bool)
+	var jobs []*Job
+	for i, jc := range configs {
+		job, err := newJob(jc, defaults, pg)
+		if err != nil {
+			log.Fatalf("❌ Error in jobs[%d]: %v", i, err)
+		}
+		if names[job.Name] {
+			log.Fatalf("❌ Error: duplicate job name %q", job.Name)
+		}
+		names[job.Name] = true
+
+		log.Printf("📌 Loaded job %q: %+v\n", job.Name, *job.Backup)
+		jobs = append(jobs, job)
+	}
+	if err := checkKeyConflicts(jobs); err != nil {
+		log.Fatalf("❌ Error in jobs: %v", err)
+	}
+	return jobs
+}
+
+// checkKeyConflicts fails when a key of one job matches the key template of
+// another, the retention of either job would delete the other's backups
+func checkKeyConflicts(jobs []*Job) error {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	for i, a := range jobs {
+		key := a.Backup.Key.Render(a.KeyVars(at))
+		for j, b := range jobs {
+			if i == j {
+				continue
+			}
+			if _, ok := b.Backup.Key.Match(key, b.KeyVars(at)); ok {
+				return fmt.Errorf("jobs %q and %q store backups under the same keys, such as %s; set a different key_template or cluster",
+					a.Name, b.Name, key)
+			}
+		}
+	}
+	return nil
+}
+
+func newJob(jc jobConfig, defaults *BackupConfig, pg *Postgres) (*Job, error) {
+	postgres := *pg
+	if jc.Host != "" {
+		postgres.Host = jc.Host
+	}
+	if jc.Port != "" {
+		postgres.Port = jc.Port
+	}
+	if jc.User != "" {
+		postgres.User = jc.User
+	}
+	if jc.PasswordEnv != "" {
+		postgres.Password = v.GetString(jc.PasswordEnv)
+	}
+	if jc.Dbname != "" {
+		postgres.Dbname = jc.Dbname
+	}
+
+	backup := *defaults
+	backup.Job = jc.Name
+	if backup.Job == "" {
+		backup.Job = postgres.Dbname
+	}
+	if len(jc.Times) > 0 {
+		backup.Times = jc.Times
+	}
+	if jc.KeepCopies != nil {
+		backup.KeepCopies = *jc.KeepCopies
+	}
+	if jc.Cluster != "" {
+		backup.Cluster = jc.Cluster
+	}
+	if backup.Cluster == "" {
+		backup.Cluster = postgres.Host
+	}
+	if jc.MaxAge != nil {
+		backup.MaxAge = *jc.MaxAge
+	}
+	backup.Phases = backup.Phases.override(jc.Phases)
+	if err := backup.Phases.complete(); err != nil {
+		return nil, err
+	}
+	backup.Hooks = backup.Hooks.override(jc.Hooks)
+	if err := backup.Hooks.complete(); err != nil {
+		return nil, err
+	}
+	if jc.Type != "" {
+		backup.Type = jc.Type
+	}
+	backup.BaseBackup = backup.BaseBackup.override(jc.BaseBackup)
+	if err := backup.completeType(); err != nil {
+		return nil, err
+	}
+	if err := backup.physicalKey(jc.KeyTemplate); err != nil {
+		return nil, err
+	}
+	if jc.KeyTemplate != "" {
+		key, err := keytpl.Parse(jc.KeyTemplate)
+		if err != nil {
+			return nil, err
+		}
+		backup.KeyTemplate = jc.KeyTemplate
+		backup.Key = key
+	}
+
+	required := []struct{ field, value string }{
+		{"host", postgres.Host},
+		{"port", postgres.Port},
+		{"user", postgres.User},
+		{"password", postgres.Password},
+		{"dbname", postgres.Dbname},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			return nil, fmt.Errorf("job %q has no %s and no POSTGRESQL_* default", backup.Job, r.field)
+		}
+	}
+
+	job := &Job{Name: backup.Job, Postgres: &postgres, Backup: &backup, Routes: jc.Notify}
+	// A cluster defaulting to a Unix socket directory can't be part of a key
+	if err := backup.Key.Check(job.KeyVars(time.Time{})); err != nil {
+		return nil, fmt.Errorf("key_template: %w", err)
+	}
+	return job, nil
+}
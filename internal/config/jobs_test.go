@@ -0,0 +1,68 @@
+package config
+
+import (
+	"PostgresDump/pkg/keytpl"
+	"strings"
+	"testing"
+)
+
+func testJob(t *testing.T, name, db, template string) *Job {
+	t.Helper()
+	key, err := keytpl.Parse(template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Job{
+		Name:     name,
+		Postgres: &Postgres{Host: "db1", Port: "5432", Dbname: db},
+		Backup:   &BackupConfig{Cluster: "main", KeyTemplate: template, Key: key},
+	}
+}
+
+func TestCheckKeyConflicts(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs []*Job
+		err  bool
+	}{
+		{
+			name: "logical and physical of one database",
+			jobs: []*Job{
+				testJob(t, "dump", "app", keytpl.DefaultTemplate),
+				testJob(t, "base", "app", keytpl.DefaultPhysicalTemplate),
+			},
+		},
+		{
+			name: "other databases",
+			jobs: []*Job{
+				testJob(t, "app", "app", keytpl.DefaultTemplate),
+				testJob(t, "crm", "crm", keytpl.DefaultTemplate),
+			},
+		},
+		{
+			name: "template shared on one database",
+			jobs: []*Job{
+				testJob(t, "dump", "app", keytpl.DefaultTemplate),
+				testJob(t, "base", "app", keytpl.DefaultTemplate),
+			},
+			err: true,
+		},
+		{
+			name: "different templates with the same keys",
+			jobs: []*Job{
+				testJob(t, "dump", "app", "{cluster}/{db}/{timestamp}.dump"),
+				testJob(t, "other", "app", "main/{db}/{timestamp}.dump"),
+			},
+			err: true,
+		},
+	}
+	for _, tt := range tests {
+		err := checkKeyConflicts(tt.jobs)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: checkKeyConflicts = %v, want error %v", tt.name, err, tt.err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "same keys") {
+			t.Errorf("%s: error = %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"PostgresDump/pkg/leader"
+	"fmt"
+	v "github.com/spf13/viper"
+	"log"
+	"os"
+	"time"
+)
+
+// Leader election backends
+const (
+	LeaderPostgres = "postgres"
+	LeaderS3       = "s3"
+)
+
+// leasePrefix is where the S3 lease objects are stored, outside of any backup key
+const leasePrefix = "_pgsnapsafe/leases/"
+
+// LeaderElection configures which of several replicas runs each job
+type LeaderElection struct {
+	// Backend is postgres or s3, leader election is disabled when empty
+	Backend string `mapstructure:"backend"`
+	// LeaseTTL is how long a dead leader keeps an S3 lease, locks are renewed
+	// every third of it
+	LeaseTTL time.Duration `mapstructure:"lease_ttl"`
+	// InstanceID names this replica in the leases, the hostname and the
+	// process ID when not set
+	InstanceID string `mapstructure:"instance_id"`
+}
+
+// JobLease and DigestLease name the leadership of a job and a digest
+func JobLease(job *Job) string { return "job/" + job.Name }
+
+func DigestLease(d *Digest) string { return "digest/" + d.Name }
+
+// Leads reports whether this instance runs the named job or digest, always
+// true without leader election
+func (c *Config) Leads(name string) bool {
+	return c.Leader == nil || c.Leader.Leads(name)
+}
+
+// loadLeaderElection reads leader_election and registers a lock for every job
+// and digest. With the postgres backend a job's lock lives on its own server
+// and digests use the server of the first job.
+func loadLeaderElection(cfg *Config) *leader.Elector {
+	var le LeaderElection
+	if err := v.UnmarshalKey("leader_election", &le); err != nil {
+		log.Fatalf("❌ Error processing leader_election: %v", err)
+	}
+	if le.Backend == "" {
+		return nil
+	}
+	if le.LeaseTTL == 0 {
+		le.LeaseTTL = 30 * time.Second
+	}
+	if le.LeaseTTL < 3*time.Second {
+		log.Fatalf("❌ Error: leader_election.lease_ttl must be at least 3s")
+	}
+	if le.InstanceID == "" {
+		host, _ := os.Hostname()
+		le.InstanceID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+
+	var newLock func(name string, job *Job) leader.Lock
+	switch le.Backend {
+	case LeaderPostgres:
+		newLock = func(name string, job *Job) leader.Lock {
+			return leader.NewPostgresLock(job.Postgres.DSN(), name)
+		}
+	case LeaderS3:
+		if cfg.S3Client == nil {
+			log.Fatalf("❌ Error: leader_election.backend s3 requires S3 storage")
+		}
+		newLock = func(name string, _ *Job) leader.Lock {
+			return leader.NewS3Lock(cfg.S3Client, cfg.BucketName, leasePrefix+name, le.InstanceID, le.LeaseTTL)
+		}
+	default:
+		log.Fatalf("❌ Error: unknown leader_election.backend %q, expected postgres or s3", le.Backend)
+	}
+
+	e := leader.New(le.InstanceID, cfg.Log, le.LeaseTTL/3)
+	for _, job := range cfg.Jobs {
+		e.Add(JobLease(job), newLock(JobLease(job), job))
+	}
+	for _, d := range cfg.Digests {
+		e.Add(DigestLease(d), newLock(DigestLease(d), cfg.Jobs[0]))
+	}
+	return e
+}
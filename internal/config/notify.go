@@ -0,0 +1,277 @@
+package config
+
+import (
+	"PostgresDump/pkg/email"
+	"PostgresDump/pkg/notify"
+	"context"
+	"fmt"
+	v "github.com/spf13/viper"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emailChannel is the channel added automatically when SMTP is enabled
+const emailChannel = "email"
+
+// channelConfig is an entry of notifications.channels
+type channelConfig struct {
+	Name string `mapstructure:"name"`
+	Type string `mapstructure:"type"`
+	// webhook, slack and mattermost
+	URL     string            `mapstructure:"url"`
+	URLEnv  string            `mapstructure:"url_env"`
+	Headers map[string]string `mapstructure:"headers"`
+	// webhook, emails always show download links
+	DownloadLinks bool `mapstructure:"download_links"`
+	// email, each a list or a comma-separated string
+	To  []string `mapstructure:"to"`
+	Cc  []string `mapstructure:"cc"`
+	Bcc []string `mapstructure:"bcc"`
+	// telegram
+	BotTokenEnv string `mapstructure:"bot_token_env"`
+	ChatID      string `mapstructure:"chat_id"`
+	// mattermost
+	Channel  string `mapstructure:"channel"`
+	Username string `mapstructure:"username"`
+	// digest
+	Period   string   `mapstructure:"period"`
+	Time     string   `mapstructure:"time"`
+	Weekday  string   `mapstructure:"weekday"`
+	Channels []string `mapstructure:"channels"`
+	Jobs     []string `mapstructure:"jobs"`
+}
+
+// Digest periodically sends a summary of all runs to its channels. Events
+// routed to a digest aren't sent on their own.
+type Digest struct {
+	Name     string
+	Period   string
+	Time     string
+	Weekday  time.Weekday
+	Channels []string
+	// Jobs limits the digest to these jobs, empty means every job
+	Jobs []string
+}
+
+// Window returns the period covered by a digest sent at t
+func (d *Digest) Window(t time.Time) (from, to time.Time) {
+	if d.Period == notify.PeriodWeekly {
+		return t.AddDate(0, 0, -7), t
+	}
+	return t.AddDate(0, 0, -1), t
+}
+
+// Digest returns the digest with the given name
+func (c *Config) Digest(name string) (*Digest, error) {
+	for _, d := range c.Digests {
+		if d.Name == name {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown digest %q", name)
+}
+
+// digestChannel stands in for a digest in routes, reports routed to it are
+// only recorded in the run history
+type digestChannel struct{}
+
+func (digestChannel) Notify(context.Context, notify.Report) error { return nil }
+
+func (digestChannel) NotifyDigest(context.Context, notify.Digest) error { return nil }
+
+// loadNotifier builds the notification channels and the default routes, and
+// checks the routes of every job against them
+func loadNotifier(cfg *Config) *notify.Dispatcher {
+	d := &notify.Dispatcher{Log: cfg.Log, Channels: make(map[string]notify.Notifier)}
+
+	if cfg.SMTPClient != nil {
+		d.Channels[emailChannel] = &email.Notifier{Client: cfg.SMTPClient, Recipients: cfg.EmailRecipients}
+	}
+
+	var channels []channelConfig
+	if err := v.UnmarshalKey("notifications.channels", &channels); err != nil {
+		log.Fatalf("❌ Error processing notifications.channels: %v", err)
+	}
+	for i, cc := range channels {
+		if cc.Name == "" {
+			log.Fatalf("❌ Error in notifications.channels[%d]: name is required", i)
+		}
+		if _, exists := d.Channels[cc.Name]; exists && cc.Name != emailChannel {
+			log.Fatalf("❌ Error: duplicate notification channel %q", cc.Name)
+		}
+		if cc.Type == "digest" {
+			digest, err := newDigest(cc, cfg)
+			if err != nil {
+				log.Fatalf("❌ Error in notification channel %q: %v", cc.Name, err)
+			}
+			cfg.Digests = append(cfg.Digests, digest)
+			d.Channels[cc.Name] = digestChannel{}
+			continue
+		}
+		channel, err := newChannel(cfg, cc)
+		if err != nil {
+			log.Fatalf("❌ Error in notification channel %q: %v", cc.Name, err)
+		}
+		d.Channels[cc.Name] = channel
+	}
+	for _, digest := range cfg.Digests {
+		for _, name := range digest.Channels {
+			channel, ok := d.Channels[name]
+			if !ok {
+				log.Fatalf("❌ Error in digest %q: unknown channel %q", digest.Name, name)
+			}
+			if _, isDigest := channel.(digestChannel); isDigest {
+				log.Fatalf("❌ Error in digest %q: channel %q is a digest itself", digest.Name, name)
+			}
+		}
+	}
+
+	if v.IsSet("notifications.routes") {
+		if err := v.UnmarshalKey("notifications.routes", &d.Routes); err != nil {
+			log.Fatalf("❌ Error processing notifications.routes: %v", err)
+		}
+	} else {
+		d.Routes = []notify.Route{defaultRoute(d.Channels)}
+	}
+
+	if err := checkRoutes(d.Routes, d.Channels); err != nil {
+		log.Fatalf("❌ Error in notifications.routes: %v", err)
+	}
+	for _, job := range cfg.Jobs {
+		if err := checkRoutes(job.Routes, d.Channels); err != nil {
+			log.Fatalf("❌ Error in notify of job %q: %v", job.Name, err)
+		}
+	}
+	return d
+}
+
+func newChannel(cfg *Config, cc channelConfig) (notify.Notifier, error) {
+	url := cc.URL
+	if cc.URLEnv != "" {
+		url = v.GetString(cc.URLEnv)
+	}
+
+	if cc.DownloadLinks && cc.Type != "webhook" {
+		return nil, fmt.Errorf("download_links only applies to webhook channels")
+	}
+
+	switch cc.Type {
+	case "email":
+		if cfg.SMTPClient == nil {
+			return nil, fmt.Errorf("email channels need SMTP enabled")
+		}
+		r := email.Recipients{
+			To:  email.ParseAddresses(cc.To),
+			Cc:  email.ParseAddresses(cc.Cc),
+			Bcc: email.ParseAddresses(cc.Bcc),
+		}
+		if len(r.All()) == 0 {
+			return nil, fmt.Errorf("to, cc or bcc is required")
+		}
+		return &email.Notifier{Client: cfg.SMTPClient, Recipients: r}, nil
+	case "webhook":
+		if url == "" {
+			return nil, fmt.Errorf("url or url_env is required")
+		}
+		return &notify.Webhook{URL: url, Headers: cc.Headers, DownloadLinks: cc.DownloadLinks}, nil
+	case "slack":
+		if url == "" {
+			return nil, fmt.Errorf("url or url_env is required")
+		}
+		return &notify.Slack{URL: url}, nil
+	case "mattermost":
+		if url == "" {
+			return nil, fmt.Errorf("url or url_env is required")
+		}
+		return &notify.Mattermost{URL: url, Channel: cc.Channel, Username: cc.Username}, nil
+	case "telegram":
+		token := v.GetString(cc.BotTokenEnv)
+		if token == "" || cc.ChatID == "" {
+			return nil, fmt.Errorf("bot_token_env pointing to a set variable and chat_id are required")
+		}
+		return &notify.Telegram{BotToken: token, ChatID: cc.ChatID}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q, expected email, webhook, slack, mattermost, telegram or digest", cc.Type)
+}
+
+func newDigest(cc channelConfig, cfg *Config) (*Digest, error) {
+	d := &Digest{Name: cc.Name, Period: cc.Period, Time: cc.Time, Channels: cc.Channels, Jobs: cc.Jobs}
+	if d.Period == "" {
+		d.Period = notify.PeriodDaily
+	}
+	if d.Period != notify.PeriodDaily && d.Period != notify.PeriodWeekly {
+		return nil, fmt.Errorf("unknown period %q, expected daily or weekly", d.Period)
+	}
+	if d.Time == "" {
+		d.Time = "08:00"
+	}
+	if _, err := time.Parse("15:04", d.Time); err != nil {
+		return nil, fmt.Errorf("invalid time %q, expected HH:MM", d.Time)
+	}
+	if d.Period == notify.PeriodWeekly {
+		d.Weekday = time.Monday
+		if cc.Weekday != "" {
+			weekday, ok := weekdays[strings.ToLower(cc.Weekday)]
+			if !ok {
+				return nil, fmt.Errorf("unknown weekday %q", cc.Weekday)
+			}
+			d.Weekday = weekday
+		}
+	}
+	if len(d.Channels) == 0 {
+		return nil, fmt.Errorf("channels is required")
+	}
+	for _, name := range d.Jobs {
+		if _, err := cfg.Job(name); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// defaultRoute sends the events listed in notifications.events to every channel
+func defaultRoute(channels map[string]notify.Notifier) notify.Route {
+	route := notify.Route{Events: notify.Events}
+	if v.IsSet("notifications.events") {
+		if err := v.UnmarshalKey("notifications.events", &route.Events); err != nil {
+			log.Fatalf("❌ Error processing notifications.events: %v", err)
+		}
+		if len(route.Events) == 0 {
+			// An explicitly empty list turns notifications off
+			return notify.Route{}
+		}
+	}
+	for name := range channels {
+		route.Channels = append(route.Channels, name)
+	}
+	sort.Strings(route.Channels)
+	return route
+}
+
+func checkRoutes(routes []notify.Route, channels map[string]notify.Notifier) error {
+	for i, route := range routes {
+		for _, event := range route.Events {
+			if !event.IsKnown() {
+				return fmt.Errorf("route %d: unknown event %q, expected one of %v", i, event, notify.Events)
+			}
+		}
+		for _, name := range route.Channels {
+			if _, ok := channels[name]; !ok {
+				return fmt.Errorf("route %d: unknown channel %q", i, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"PostgresDump/pkg/retry"
+	"fmt"
+	"time"
+)
+
+// Phases of a backup run, used as keys of the recorded attempts
+const (
+	PhaseDump   = "dump"
+	PhaseUpload = "upload"
+	PhaseNotify = "notify"
+)
+
+// defaultNotifyTimeout keeps a slow notification channel from holding up the
+// scheduler or a shutdown
+const defaultNotifyTimeout = 30 * time.Second
+
+// Phases holds the timeout and retry policy of every phase of a backup run
+type Phases struct {
+	Dump   retry.Policy `mapstructure:"dump"`
+	Upload retry.Policy `mapstructure:"upload"`
+	Notify retry.Policy `mapstructure:"notify"`
+}
+
+// override returns the phases with the ones set in o replaced
+func (p Phases) override(o Phases) Phases {
+	if o.Dump != (retry.Policy{}) {
+		p.Dump = o.Dump
+	}
+	if o.Upload != (retry.Policy{}) {
+		p.Upload = o.Upload
+	}
+	if o.Notify != (retry.Policy{}) {
+		p.Notify = o.Notify
+	}
+	return p
+}
+
+// complete fills in the notification timeout and validates every phase
+func (p *Phases) complete() error {
+	if p.Notify.Timeout == 0 {
+		p.Notify.Timeout = defaultNotifyTimeout
+	}
+	for name, policy := range map[string]retry.Policy{PhaseDump: p.Dump, PhaseUpload: p.Upload, PhaseNotify: p.Notify} {
+		if err := policy.Validate(); err != nil {
+			return fmt.Errorf("phases.%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// MaxRunDuration returns the longest a backup run of the job can take in its
+// phases and hooks, 0 when the dump or upload phase has no timeout. A run
+// sends up to two reports, one for the backup and one for a failed cleanup.
+func (b *BackupConfig) MaxRunDuration() time.Duration {
+	dump, upload := b.Phases.Dump.MaxDuration(), b.Phases.Upload.MaxDuration()
+	if dump == 0 || upload == 0 {
+		return 0
+	}
+	total := dump + upload + 2*b.Phases.Notify.MaxDuration()
+	for _, stage := range []string{HookPre, HookPost, HookOnFailure} {
+		for _, hook := range b.Hooks.Stage(stage) {
+			total += hook.Timeout
+		}
+	}
+	return total
+}
@@ -0,0 +1,130 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of recorded runs
+const (
+	KindBackup = "backup"
+	KindVerify = "verify"
+)
+
+// Run statuses
+const (
+	StatusSuccess = "success"
+	StatusWarning = "warning"
+	StatusFailure = "failure"
+)
+
+// MaxAge is how long records are kept, long enough for weekly digests with a
+// previous period to compare against
+const MaxAge = 35 * 24 * time.Hour
+
+// Record is a single backup or verification run
+type Record struct {
+	Kind     string        `json:"kind"`
+	Job      string        `json:"job"`
+	Status   string        `json:"status"`
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Key      string        `json:"key,omitempty"`
+	Size     int64         `json:"size,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// Deleted is the number of backups removed by retention after the run
+	Deleted    int    `json:"deleted,omitempty"`
+	CleanupErr string `json:"cleanup_error,omitempty"`
+	// Attempts counts the attempts of every phase of the run: dump, upload and notify
+	Attempts map[string]int `json:"attempts,omitempty"`
+}
+
+// Store is a JSON-file log of recent runs
+type Store struct {
+	path    string
+	mu      sync.Mutex
+	records []Record
+}
+
+// Open loads the run history from path, a missing file starts an empty history
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run history %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &s.records); err != nil {
+		return nil, fmt.Errorf("failed to parse run history %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Add appends a record, drops records older than MaxAge and saves the history
+func (s *Store) Add(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = append(s.records, r)
+	sort.SliceStable(s.records, func(i, j int) bool {
+		return s.records[i].Time.Before(s.records[j].Time)
+	})
+
+	cutoff := time.Now().Add(-MaxAge)
+	kept := s.records[:0]
+	for _, rec := range s.records {
+		if !rec.Time.Before(cutoff) {
+			kept = append(kept, rec)
+		}
+	}
+	s.records = kept
+	return s.save()
+}
+
+// Between returns the records of the job in [from, to), oldest first. An empty
+// job matches every job.
+func (s *Store) Between(job string, from, to time.Time) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []Record
+	for _, r := range s.records {
+		if job != "" && r.Job != job {
+			continue
+		}
+		if r.Time.Before(from) || !r.Time.Before(to) {
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// save writes the history atomically, the caller must hold s.mu
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run history: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create run history directory: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write run history: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to save run history: %w", err)
+	}
+	return nil
+}
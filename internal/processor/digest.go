@@ -0,0 +1,113 @@
+package processor
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/history"
+	"PostgresDump/pkg/notify"
+	"context"
+	"strings"
+	"time"
+)
+
+// SendDigest builds the digest for the period ending at t and sends it to its channels
+func SendDigest(ctx context.Context, cfg *config.Config, digest *config.Digest, t time.Time) error {
+	d := BuildDigest(cfg, digest, t)
+	if err := cfg.Notifier.SendDigest(ctx, digest.Channels, d); err != nil {
+		return err
+	}
+	cfg.Log.Info("📊 Digest sent", "digest", digest.Name, "jobs", len(d.Jobs))
+	return nil
+}
+
+// BuildDigest summarizes the runs of the digest's jobs over the period ending at t
+func BuildDigest(cfg *config.Config, digest *config.Digest, t time.Time) notify.Digest {
+	from, to := digest.Window(t)
+	prevFrom := from.Add(-to.Sub(from))
+
+	jobs, err := cfg.SelectJobs(digest.Jobs)
+	if err != nil {
+		// Job names are validated when the config is loaded
+		jobs = cfg.Jobs
+	}
+
+	d := notify.Digest{Name: digest.Name, Period: digest.Period, From: from, To: to}
+	for _, job := range jobs {
+		jd := notify.JobDigest{
+			Job:      job.Name,
+			Host:     job.Postgres.Host,
+			Database: job.Postgres.Dbname,
+		}
+
+		records := cfg.History.Between(job.Name, from, to)
+		for _, r := range records {
+			switch r.Kind {
+			case history.KindBackup:
+				jd.Runs++
+				jd.Deleted += r.Deleted
+				switch r.Status {
+				case history.StatusFailure:
+					jd.Failures++
+				case history.StatusWarning:
+					jd.Warnings++
+				}
+			case history.KindVerify:
+				jd.Verified++
+				if r.Status == history.StatusFailure {
+					jd.VerifyFailures++
+				}
+			}
+			if r.Error != "" {
+				jd.LastError = strings.TrimSpace(r.Error)
+			}
+		}
+
+		avg := averageSize(records)
+		if prev := averageSize(cfg.History.Between(job.Name, prevFrom, from)); prev > 0 && avg > 0 {
+			jd.SizeTrend = (avg - prev) / prev * 100
+			jd.HasTrend = true
+		}
+
+		entries := cfg.Catalog.List(catalog.Filter{Job: job.Name})
+		jd.Backups = len(entries)
+		for _, e := range entries {
+			jd.TotalSize += e.Size
+		}
+		if len(entries) > 0 {
+			jd.NewestBackup = entries[0].CreatedAt
+			jd.NewestAge = to.Sub(jd.NewestBackup)
+		}
+
+		d.Jobs = append(d.Jobs, jd)
+	}
+	return d
+}
+
+// averageSize returns the average size of the backups created by the records
+func averageSize(records []history.Record) float64 {
+	var size int64
+	var n int
+	for _, r := range records {
+		if r.Kind == history.KindBackup && r.Size > 0 {
+			size += r.Size
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return float64(size) / float64(n)
+}
+
+// digestDue reports whether the digest is scheduled within 30 seconds of now
+func digestDue(digest *config.Digest, now time.Time) bool {
+	if digest.Period == notify.PeriodWeekly && now.Weekday() != digest.Weekday {
+		return false
+	}
+	at, err := time.Parse("15:04", digest.Time)
+	if err != nil {
+		return false
+	}
+	scheduled := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	return abs(now.Sub(scheduled).Seconds()) < 30
+}
@@ -0,0 +1,68 @@
+package processor
+
+import (
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/history"
+	"PostgresDump/internal/services/backups"
+	"PostgresDump/internal/services/hooks"
+	"context"
+	"time"
+)
+
+// Backup creates a backup of the job and runs its hooks: the pre hooks before
+// it, the post hooks after a successful backup and the on_failure hooks when
+// the backup or an aborting hook failed. Hook failures that only warn are
+// added to the warnings of the result. When a post hook aborts the run, the
+// created backup is returned along with the error.
+func Backup(ctx context.Context, cfg *config.Config, job *config.Job) (*backups.Result, error) {
+	started := time.Now()
+
+	warnings, err := runHooks(ctx, cfg, job, config.HookPre, hooks.Run{Status: StatusRunning})
+	var backup *backups.Result
+	if err == nil {
+		backup, err = backups.CreateBackup(ctx, cfg, job)
+	}
+	if err == nil {
+		backup.Warnings = append(backup.Warnings, warnings...)
+		run := hookRun(backup, started)
+		run.Status = history.StatusSuccess
+		if len(backup.Warnings) > 0 {
+			run.Status = history.StatusWarning
+		}
+		var post []string
+		post, err = runHooks(ctx, cfg, job, config.HookPost, run)
+		backup.Warnings = append(backup.Warnings, post...)
+	}
+	if err == nil {
+		return backup, nil
+	}
+
+	run := hookRun(backup, started)
+	run.Status, run.Error = history.StatusFailure, err.Error()
+	// Like notifications, failure hooks also run when the backup was aborted
+	runHooks(context.WithoutCancel(ctx), cfg, job, config.HookOnFailure, run)
+	return backup, err
+}
+
+// runHooks runs the hooks of a stage and counts their failures
+func runHooks(ctx context.Context, cfg *config.Config, job *config.Job, stage string, run hooks.Run) ([]string, error) {
+	warnings, err := hooks.RunStage(ctx, cfg, job, stage, run)
+	failures := len(warnings)
+	if err != nil {
+		failures++
+	}
+	hookFailures.Add(float64(failures), job.Name, stage)
+	return warnings, err
+}
+
+// hookRun describes a created backup, or nothing when there is none, to hooks
+func hookRun(backup *backups.Result, started time.Time) hooks.Run {
+	run := hooks.Run{Duration: time.Since(started)}
+	if backup != nil {
+		run.File = backup.File
+		run.Key = backup.Entry.Key
+		run.Size = backup.Entry.Size
+		run.SHA256 = backup.Entry.SHA256
+	}
+	return run
+}
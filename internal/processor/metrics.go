@@ -0,0 +1,180 @@
+package processor
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/history"
+	"PostgresDump/internal/services/backups"
+	"PostgresDump/pkg/metrics"
+	"PostgresDump/pkg/notify"
+	"time"
+)
+
+// Metrics holds the Prometheus metrics served on /metrics
+var Metrics = metrics.NewRegistry()
+
+var (
+	backupDuration = Metrics.Histogram("pgsnapsafe_backup_duration_seconds",
+		"Duration of backup runs.",
+		[]float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200},
+		"job", "status")
+	backupRuns = Metrics.Counter("pgsnapsafe_backup_runs_total",
+		"Backup runs by status: success, warning or failure.", "job", "status")
+	dumpBytes = Metrics.Counter("pgsnapsafe_dump_bytes_total",
+		"Bytes written by pg_dump.", "job")
+	uploadBytes = Metrics.Counter("pgsnapsafe_upload_bytes_total",
+		"Bytes uploaded to S3.", "job")
+	lastSuccess = Metrics.Gauge("pgsnapsafe_last_success_timestamp_seconds",
+		"Unix time of the last run that created a backup.", "job")
+	lastFailure = Metrics.Gauge("pgsnapsafe_last_failure_timestamp_seconds",
+		"Unix time of the last failed run.", "job")
+	consecutiveFailures = Metrics.Gauge("pgsnapsafe_consecutive_failures",
+		"Failed runs since the last backup was created.", "job")
+	retentionDeleted = Metrics.Counter("pgsnapsafe_retention_deleted_total",
+		"Backups deleted by retention.", "job")
+	cleanupErrors = Metrics.Counter("pgsnapsafe_cleanup_errors_total",
+		"Failed retention cleanups.", "job")
+	hookFailures = Metrics.Counter("pgsnapsafe_hook_failures_total",
+		"Failed backup hooks by stage: pre, post or on_failure.", "job", "stage")
+	verifications = Metrics.Counter("pgsnapsafe_verifications_total",
+		"Backup verifications by result: success or failure.", "job", "result")
+	lastVerification = Metrics.Gauge("pgsnapsafe_last_verification_timestamp_seconds",
+		"Unix time of the last backup verification.", "job")
+	lastVerificationOK = Metrics.Gauge("pgsnapsafe_last_verification_success",
+		"1 when the last backup verification succeeded, 0 otherwise.", "job")
+)
+
+// InitMetrics seeds the run metrics from the history and registers the metrics
+// computed from the schedule and the catalog on every scrape
+func InitMetrics(cfg *config.Config) {
+	now := time.Now()
+	for _, job := range cfg.Jobs {
+		for _, r := range cfg.History.Between(job.Name, time.Time{}, now.Add(time.Second)) {
+			switch r.Kind {
+			case history.KindBackup:
+				observeRunStatus(job.Name, r.Status, r.Time)
+			case history.KindVerify:
+				observeVerifyResult(job.Name, r.Status == history.StatusSuccess, r.Time)
+			}
+		}
+	}
+
+	Metrics.GaugeFunc("pgsnapsafe_next_run_timestamp_seconds",
+		"Unix time of the next scheduled backup.", []string{"job"},
+		func(emit func(float64, ...string)) {
+			now := time.Now()
+			for _, job := range cfg.Jobs {
+				if next, ok := NextRun(job, now); ok {
+					emit(float64(next.Unix()), job.Name)
+				}
+			}
+		})
+	Metrics.GaugeFunc("pgsnapsafe_newest_backup_timestamp_seconds",
+		"Unix time of the newest stored backup.", []string{"job"},
+		func(emit func(float64, ...string)) {
+			for _, job := range cfg.Jobs {
+				if entries := cfg.Catalog.List(catalog.Filter{Job: job.Name}); len(entries) > 0 {
+					emit(float64(entries[0].CreatedAt.Unix()), job.Name)
+				}
+			}
+		})
+	Metrics.GaugeFunc("pgsnapsafe_running_backups", "Backups in progress.", nil,
+		func(emit func(float64, ...string)) { emit(float64(Running())) })
+	Metrics.GaugeFunc("pgsnapsafe_queued_backups", "Backups waiting for a free concurrency slot.", nil,
+		func(emit func(float64, ...string)) { emit(float64(Queued())) })
+	if cfg.Leader != nil {
+		Metrics.GaugeFunc("pgsnapsafe_leader", "1 when this instance leads the job or digest, 0 otherwise.", []string{"name"},
+			func(emit func(float64, ...string)) {
+				for _, name := range cfg.Leader.Names() {
+					if cfg.Leader.Leads(name) {
+						emit(1, name)
+					} else {
+						emit(0, name)
+					}
+				}
+			})
+	}
+	Metrics.GaugeFunc("pgsnapsafe_stored_backups", "Number of stored backups.", []string{"job"},
+		func(emit func(float64, ...string)) {
+			for _, job := range cfg.Jobs {
+				emit(float64(len(cfg.Catalog.List(catalog.Filter{Job: job.Name}))), job.Name)
+			}
+		})
+	Metrics.GaugeFunc("pgsnapsafe_stored_bytes", "Total size of the stored backups.", []string{"job"},
+		func(emit func(float64, ...string)) {
+			for _, job := range cfg.Jobs {
+				var size int64
+				for _, e := range cfg.Catalog.List(catalog.Filter{Job: job.Name}) {
+					size += e.Size
+				}
+				emit(float64(size), job.Name)
+			}
+		})
+}
+
+// NextRun returns the next scheduled backup time of the job after now
+func NextRun(job *config.Job, now time.Time) (time.Time, bool) {
+	var next time.Time
+	for _, t := range job.Backup.Times {
+		at, err := time.Parse("15:04", t)
+		if err != nil {
+			continue
+		}
+		scheduled := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+		if !scheduled.After(now) {
+			scheduled = scheduled.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || scheduled.Before(next) {
+			next = scheduled
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// observeRun updates the metrics after a backup run
+func observeRun(job *config.Job, report notify.Report, backup *backups.Result, deleted int, cleanupErr error) {
+	status := string(report.Event)
+	backupDuration.Observe(report.Duration.Seconds(), job.Name, status)
+	backupRuns.Inc(job.Name, status)
+	observeRunStatus(job.Name, status, report.Time)
+
+	if backup != nil {
+		dumpBytes.Add(float64(backup.Entry.Size), job.Name)
+		if backup.Entry.Location == catalog.LocationS3 {
+			uploadBytes.Add(float64(backup.Entry.Size), job.Name)
+		}
+	}
+	retentionDeleted.Add(float64(deleted), job.Name)
+	if cleanupErr != nil {
+		cleanupErrors.Inc(job.Name)
+	}
+}
+
+func observeRunStatus(job, status string, t time.Time) {
+	if status == history.StatusFailure {
+		lastFailure.Set(float64(t.Unix()), job)
+		consecutiveFailures.Add(1, job)
+		return
+	}
+	lastSuccess.Set(float64(t.Unix()), job)
+	consecutiveFailures.Set(0, job)
+}
+
+// ObserveVerify updates the metrics after a backup verification
+func ObserveVerify(entry catalog.Entry, started time.Time, verifyErr error) {
+	result := history.StatusSuccess
+	if verifyErr != nil {
+		result = history.StatusFailure
+	}
+	verifications.Inc(entry.Job, result)
+	observeVerifyResult(entry.Job, verifyErr == nil, started)
+}
+
+func observeVerifyResult(job string, ok bool, t time.Time) {
+	lastVerification.Set(float64(t.Unix()), job)
+	if ok {
+		lastVerificationOK.Set(1, job)
+	} else {
+		lastVerificationOK.Set(0, job)
+	}
+}
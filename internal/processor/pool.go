@@ -0,0 +1,184 @@
+package processor
+
+import (
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/history"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// queued is the number of backups waiting for a free slot
+var queued atomic.Int32
+
+// Queued returns the number of backups waiting for a free slot
+func Queued() int {
+	return int(queued.Load())
+}
+
+// Pool runs jobs concurrently within the limits of cfg.Concurrency. A job is
+// never queued or run twice at the same time.
+type Pool struct {
+	cfg *config.Config
+	// ctx stops queued jobs from starting, work is passed to running jobs and
+	// is canceled ShutdownGrace after ctx
+	ctx   context.Context
+	work  context.Context
+	abort context.CancelFunc
+	wg    sync.WaitGroup
+
+	global chan struct{}
+	mu     sync.Mutex
+	hosts  map[string]chan struct{}
+	// active maps the jobs queued or running to their run IDs
+	active map[string]string
+	runs   runs
+	// run runs a job, RunJob outside of tests
+	run func(ctx context.Context, cfg *config.Config, job *config.Job) JobResult
+}
+
+// NewPool creates a pool that stops starting jobs when ctx is done. Jobs that
+// are running by then may finish within cfg.ShutdownGrace.
+func NewPool(ctx context.Context, cfg *config.Config) *Pool {
+	work, abort := withGrace(ctx, cfg.ShutdownGrace)
+	return &Pool{
+		cfg:    cfg,
+		ctx:    ctx,
+		work:   work,
+		abort:  abort,
+		global: make(chan struct{}, max(cfg.Concurrency.MaxJobs, 1)),
+		hosts:  make(map[string]chan struct{}),
+		active: make(map[string]string),
+		runs:   runs{byID: make(map[string]*RunStatus)},
+		run:    RunJob,
+	}
+}
+
+// Submit queues a run of the job and calls done with its result, trigger tells
+// what started the run. When a run of the job is already queued or running it
+// returns that run with ErrAlreadyQueued.
+func (p *Pool) Submit(job *config.Job, trigger string, done func(JobResult)) (RunStatus, error) {
+	p.mu.Lock()
+	if id, ok := p.active[job.Name]; ok {
+		p.mu.Unlock()
+		status, _ := p.Status(id)
+		return status, ErrAlreadyQueued
+	}
+	if p.ctx.Err() != nil {
+		p.mu.Unlock()
+		return RunStatus{}, ErrShuttingDown
+	}
+	status := p.runs.add(job.Name, trigger)
+	p.active[job.Name] = status.ID
+	p.mu.Unlock()
+
+	queued.Add(1)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() {
+			p.mu.Lock()
+			delete(p.active, job.Name)
+			p.mu.Unlock()
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				p.cfg.Log.Error("⚠️ Critical error in backup process", "job", job.Name, "error", r)
+				p.runs.finish(status.ID, JobResult{Job: job.Name, Status: history.StatusFailure, BackupErr: fmt.Errorf("%v", r)})
+			}
+		}()
+
+		release, ok := p.acquire(job)
+		queued.Add(-1)
+		if !ok {
+			p.cfg.Log.Warn("🛑 Queued backup canceled by shutdown", "job", job.Name, "run", status.ID)
+			p.runs.cancel(status.ID)
+			return
+		}
+		defer release()
+
+		p.runs.start(status.ID)
+		result := p.run(p.work, p.cfg, job)
+		p.runs.finish(status.ID, result)
+		if done != nil {
+			done(result)
+		}
+	}()
+	return status, nil
+}
+
+// Status returns a run submitted to the pool, only the most recent runs are kept
+func (p *Pool) Status(id string) (RunStatus, bool) {
+	return p.runs.get(id)
+}
+
+// Runs returns the most recent runs newest first, without their logs
+func (p *Pool) Runs() []RunStatus {
+	return p.runs.list()
+}
+
+// Active returns the queued or running run of the job
+func (p *Pool) Active(job string) (RunStatus, bool) {
+	p.mu.Lock()
+	id, ok := p.active[job]
+	p.mu.Unlock()
+	if !ok {
+		return RunStatus{}, false
+	}
+	return p.runs.get(id)
+}
+
+// Close waits for the submitted jobs to finish or give up
+func (p *Pool) Close() {
+	p.wg.Wait()
+	p.abort()
+}
+
+// acquire waits for a slot on the job's server and then for a global one, it
+// returns false when ctx is done first
+func (p *Pool) acquire(job *config.Job) (release func(), ok bool) {
+	host := p.hostSlots(job)
+	if host != nil {
+		select {
+		case host <- struct{}{}:
+		case <-p.ctx.Done():
+			return nil, false
+		}
+	}
+	select {
+	case p.global <- struct{}{}:
+	case <-p.ctx.Done():
+		if host != nil {
+			<-host
+		}
+		return nil, false
+	}
+	release = func() {
+		<-p.global
+		if host != nil {
+			<-host
+		}
+	}
+	// select picks randomly when a slot frees up as the shutdown begins
+	if p.ctx.Err() != nil {
+		release()
+		return nil, false
+	}
+	return release, true
+}
+
+// hostSlots returns the semaphore of the job's server, nil without a per-host limit
+func (p *Pool) hostSlots(job *config.Job) chan struct{} {
+	if p.cfg.Concurrency.PerHost <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	slots, ok := p.hosts[job.Server()]
+	if !ok {
+		slots = make(chan struct{}, p.cfg.Concurrency.PerHost)
+		p.hosts[job.Server()] = slots
+	}
+	return slots
+}
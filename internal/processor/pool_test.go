@@ -0,0 +1,269 @@
+package processor
+
+import (
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/history"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubRunner replaces RunJob and records how many runs overlap
+type stubRunner struct {
+	mu         sync.Mutex
+	running    int
+	maxRunning int
+	hosts      map[string]int
+	maxPerHost int
+	// release lets the runs finish
+	release chan struct{}
+}
+
+func newStubRunner() *stubRunner {
+	return &stubRunner{hosts: make(map[string]int), release: make(chan struct{})}
+}
+
+func (s *stubRunner) run(ctx context.Context, cfg *config.Config, job *config.Job) JobResult {
+	s.mu.Lock()
+	s.running++
+	s.maxRunning = max(s.maxRunning, s.running)
+	s.hosts[job.Server()]++
+	s.maxPerHost = max(s.maxPerHost, s.hosts[job.Server()])
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.running--
+		s.hosts[job.Server()]--
+		s.mu.Unlock()
+	}()
+
+	select {
+	case <-s.release:
+		return JobResult{Job: job.Name, Status: history.StatusSuccess}
+	case <-ctx.Done():
+		return JobResult{Job: job.Name, Status: history.StatusFailure, BackupErr: ctx.Err()}
+	}
+}
+
+func (s *stubRunner) counts() (running, maxRunning, maxPerHost int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running, s.maxRunning, s.maxPerHost
+}
+
+func newTestPool(ctx context.Context, limits config.Concurrency, grace time.Duration, stub *stubRunner) *Pool {
+	cfg := &config.Config{
+		Log:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Concurrency:   limits,
+		ShutdownGrace: grace,
+	}
+	p := NewPool(ctx, cfg)
+	p.run = stub.run
+	return p
+}
+
+func testJob(name, host string) *config.Job {
+	return &config.Job{Name: name, Postgres: &config.Postgres{Host: host, Port: "5432"}}
+}
+
+// waitFor fails the test when cond doesn't become true within a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// results collects the results passed to the done callbacks
+type results struct {
+	mu     sync.Mutex
+	byName map[string]JobResult
+}
+
+func (r *results) done(result JobResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byName[result.Job] = result
+}
+
+func (r *results) get(job string) (JobResult, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result, ok := r.byName[job]
+	return result, ok
+}
+
+func TestPoolGlobalLimit(t *testing.T) {
+	stub := newStubRunner()
+	pool := newTestPool(context.Background(), config.Concurrency{MaxJobs: 2}, 0, stub)
+	res := &results{byName: make(map[string]JobResult)}
+
+	jobs := []string{"a", "b", "c", "d"}
+	for _, name := range jobs {
+		if _, err := pool.Submit(testJob(name, name+".example.com"), TriggerCLI, res.done); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "two running jobs", func() bool {
+		running, _, _ := stub.counts()
+		return running == 2
+	})
+	// Give the queued runs a chance to start if the limit didn't hold
+	time.Sleep(50 * time.Millisecond)
+	if running, _, _ := stub.counts(); running != 2 {
+		t.Errorf("%d jobs running, want 2", running)
+	}
+	if n := Queued(); n != 2 {
+		t.Errorf("Queued = %d, want 2", n)
+	}
+
+	close(stub.release)
+	pool.Close()
+	if _, maxRunning, _ := stub.counts(); maxRunning != 2 {
+		t.Errorf("at most %d jobs ran at once, want 2", maxRunning)
+	}
+	for _, name := range jobs {
+		if result, ok := res.get(name); !ok || result.Status != history.StatusSuccess {
+			t.Errorf("job %s: result %+v, done called %v", name, result, ok)
+		}
+	}
+	if n := Queued(); n != 0 {
+		t.Errorf("Queued = %d after Close, want 0", n)
+	}
+}
+
+func TestPoolPerHostLimit(t *testing.T) {
+	stub := newStubRunner()
+	pool := newTestPool(context.Background(), config.Concurrency{MaxJobs: 4, PerHost: 1}, 0, stub)
+
+	for _, job := range []*config.Job{
+		testJob("a1", "a.example.com"),
+		testJob("a2", "a.example.com"),
+		testJob("a3", "a.example.com"),
+		testJob("b1", "b.example.com"),
+	} {
+		if _, err := pool.Submit(job, TriggerCLI, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// One job of each host runs, the global limit would allow all four
+	waitFor(t, "a job of each host", func() bool {
+		running, _, _ := stub.counts()
+		return running == 2
+	})
+	time.Sleep(50 * time.Millisecond)
+	if running, _, maxPerHost := stub.counts(); running != 2 || maxPerHost != 1 {
+		t.Errorf("%d jobs running and at most %d per host, want 2 and 1", running, maxPerHost)
+	}
+
+	close(stub.release)
+	pool.Close()
+	if _, _, maxPerHost := stub.counts(); maxPerHost != 1 {
+		t.Errorf("at most %d jobs ran at once on a host, want 1", maxPerHost)
+	}
+}
+
+func TestPoolAlreadyQueued(t *testing.T) {
+	stub := newStubRunner()
+	pool := newTestPool(context.Background(), config.Concurrency{MaxJobs: 2}, 0, stub)
+
+	job := testJob("a", "a.example.com")
+	first, err := pool.Submit(job, TriggerSchedule, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pool.Submit(job, TriggerAPI, nil)
+	if !errors.Is(err, ErrAlreadyQueued) || second.ID != first.ID {
+		t.Errorf("second Submit = %s, %v, want run %s and ErrAlreadyQueued", second.ID, err, first.ID)
+	}
+	if active, ok := pool.Active("a"); !ok || active.ID != first.ID {
+		t.Errorf("Active = %+v, %v", active, ok)
+	}
+
+	close(stub.release)
+	pool.Close()
+	status, _ := pool.Status(first.ID)
+	if status.Status != history.StatusSuccess || status.StartedAt == nil || status.FinishedAt == nil {
+		t.Errorf("Status = %+v, want a finished successful run", status)
+	}
+	if _, ok := pool.Active("a"); ok {
+		t.Error("the job is still active after its run finished")
+	}
+	if _, err := pool.Submit(job, TriggerAPI, nil); err != nil {
+		t.Errorf("Submit after the run finished = %v", err)
+	}
+}
+
+func TestPoolShutdownCancelsQueued(t *testing.T) {
+	stub := newStubRunner()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool := newTestPool(ctx, config.Concurrency{MaxJobs: 1}, time.Hour, stub)
+	res := &results{byName: make(map[string]JobResult)}
+
+	running, err := pool.Submit(testJob("running", "a.example.com"), TriggerCLI, res.done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the first job to start", func() bool {
+		n, _, _ := stub.counts()
+		return n == 1
+	})
+	queuedRun, err := pool.Submit(testJob("queued", "b.example.com"), TriggerCLI, res.done)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	waitFor(t, "the queued run to be canceled", func() bool {
+		status, _ := pool.Status(queuedRun.ID)
+		return status.Status == StatusCanceled
+	})
+	if _, err := pool.Submit(testJob("late", "c.example.com"), TriggerCLI, nil); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Submit after shutdown = %v, want ErrShuttingDown", err)
+	}
+
+	// The running job finishes within the grace period
+	close(stub.release)
+	pool.Close()
+	if status, _ := pool.Status(running.ID); status.Status != history.StatusSuccess {
+		t.Errorf("running job status = %s, want success", status.Status)
+	}
+	if _, ok := res.get("queued"); ok {
+		t.Error("done was called for a canceled run")
+	}
+	if _, maxRunning, _ := stub.counts(); maxRunning != 1 {
+		t.Errorf("%d jobs ran at once, want 1", maxRunning)
+	}
+}
+
+func TestPoolShutdownGrace(t *testing.T) {
+	stub := newStubRunner()
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := newTestPool(ctx, config.Concurrency{MaxJobs: 1}, 10*time.Millisecond, stub)
+
+	status, err := pool.Submit(testJob("a", "a.example.com"), TriggerCLI, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the job to start", func() bool {
+		n, _, _ := stub.counts()
+		return n == 1
+	})
+
+	// The run is aborted once the grace period is over
+	cancel()
+	pool.Close()
+	status, _ = pool.Status(status.ID)
+	if status.Status != history.StatusFailure || status.Error != context.Canceled.Error() {
+		t.Errorf("Status = %+v, want a run aborted after the grace period", status)
+	}
+}
@@ -2,67 +2,266 @@ package processor
 
 import (
 	"PostgresDump/internal/config"
+	"PostgresDump/internal/history"
 	"PostgresDump/internal/services/backups"
-	"PostgresDump/pkg/email"
-	v "github.com/spf13/viper"
+	"PostgresDump/pkg/notify"
+	"PostgresDump/pkg/retry"
+	"context"
+	"errors"
+	"maps"
+	"sync/atomic"
 	"time"
 )
 
-func Run(cfg *config.Config) {
-	cfg.Log.Info("🔄 Starting backup cycle...")
-	cfg.Log.Info("📋 Backup schedule", "times", cfg.Backup.Times)
+var (
+	// heartbeat is the Unix time in nanoseconds of the last scheduler loop iteration
+	heartbeat atomic.Int64
+	// running is the number of backups in progress
+	running atomic.Int32
+)
+
+// Heartbeat returns when the scheduler last checked the schedule, zero before it started
+func Heartbeat() time.Time {
+	if ns := heartbeat.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// Running returns the number of backups in progress
+func Running() int {
+	return int(running.Load())
+}
+
+// Run checks the schedule every minute until ctx is done and hands due jobs to
+// the pool. The caller closes the pool, which lets backups in progress finish
+// within cfg.ShutdownGrace.
+func Run(ctx context.Context, cfg *config.Config, pool *Pool) {
+	cfg.Log.Info("🔄 Starting backup cycle...", "maxJobs", cfg.Concurrency.MaxJobs, "perHost", cfg.Concurrency.PerHost)
+	for _, job := range cfg.Jobs {
+		cfg.Log.Info("📋 Backup schedule", "job", job.Name, "times", job.Backup.Times)
+	}
 
 	lastRun := make(map[string]time.Time) // Храним последнее время выполнения бэкапа
 
 	for {
 		now := time.Now()
+		heartbeat.Store(now.UnixNano())
 
-		for _, t := range cfg.Backup.Times {
-			backupTimeParsed, err := time.Parse("15:04", t)
-			if err != nil {
-				cfg.Log.Error("Error parsing time", "time", t, "error", err)
-				continue
-			}
+		for _, job := range cfg.Jobs {
+			for _, t := range job.Backup.Times {
+				backupTimeParsed, err := time.Parse("15:04", t)
+				if err != nil {
+					cfg.Log.Error("Error parsing time", "job", job.Name, "time", t, "error", err)
+					continue
+				}
 
-			backupTime := time.Date(now.Year(), now.Month(), now.Day(),
-				backupTimeParsed.Hour(), backupTimeParsed.Minute(), 0, 0, now.Location())
+				backupTime := time.Date(now.Year(), now.Month(), now.Day(),
+					backupTimeParsed.Hour(), backupTimeParsed.Minute(), 0, 0, now.Location())
 
-			// Проверяем, запускался ли уже бэкап сегодня
-			if lastRunTime, exists := lastRun[t]; exists {
-				if lastRunTime.Day() == now.Day() {
-					continue // Бэкап уже запускался сегодня, пропускаем
+				// Проверяем, запускался ли уже бэкап сегодня
+				slot := job.Name + "@" + t
+				if lastRunTime, exists := lastRun[slot]; exists {
+					if lastRunTime.Day() == now.Day() {
+						continue // Бэкап уже запускался сегодня, пропускаем
+					}
 				}
-			}
 
-			if abs(now.Sub(backupTime).Seconds()) < 30 {
-				cfg.Log.Info("🕒 Backup time!", "time", t)
+				if abs(now.Sub(backupTime).Seconds()) < 30 {
+					if ctx.Err() != nil {
+						return
+					}
+					if !cfg.Leads(config.JobLease(job)) {
+						cfg.Log.Info("👥 Another instance leads the job, skipping", "job", job.Name, "time", t)
+						lastRun[slot] = now
+						continue
+					}
+					cfg.Log.Info("🕒 Backup time!", "job", job.Name, "time", t)
 
-				filePath, err := backups.CreateBackup(cfg)
-				if err != nil {
-					cfg.Log.Error("❌ Error creating backup", "error", err)
-				} else {
-					cfg.Log.Info("✅ Backup created successfully", "file", filePath)
-					lastRun[t] = now // Запоминаем, что бэкап уже был выполнен
+					if _, err := pool.Submit(job, TriggerSchedule, nil); errors.Is(err, ErrAlreadyQueued) {
+						cfg.Log.Warn("⏭️ Previous run is still running, skipping", "job", job.Name, "time", t)
+					}
+					lastRun[slot] = now // Запоминаем, что бэкап уже был выполнен
 				}
+			}
+		}
 
-				if err := backups.CleanupOldBackups(cfg); err != nil {
-					cfg.Log.Error("🚨 Error cleaning up old backups", "error", err)
-				} else {
-					cfg.Log.Info("🧹 Old backups cleanup completed")
+		for _, digest := range cfg.Digests {
+			slot := "digest:" + digest.Name
+			if lastRunTime, exists := lastRun[slot]; exists && lastRunTime.Day() == now.Day() {
+				continue
+			}
+			if digestDue(digest, now) {
+				if !cfg.Leads(config.DigestLease(digest)) {
+					cfg.Log.Info("👥 Another instance leads the digest, skipping", "digest", digest.Name)
+					lastRun[slot] = now
+					continue
 				}
-
-				if v.GetBool("smtp") {
-					if err = email.SendEmail(cfg.SMTPClient, v.GetString("email_delivery"), filePath); err != nil {
-						cfg.Log.Error("Error sending email", "error", err)
-					}
+				cfg.Log.Info("📊 Digest time!", "digest", digest.Name)
+				if err := SendDigest(ctx, cfg, digest, now); err == nil {
+					lastRun[slot] = now
 				}
 			}
 		}
 
-		time.Sleep(60 * time.Second) // Проверяем каждую минуту
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(60 * time.Second): // Проверяем каждую минуту
+		}
 	}
 }
 
+// withGrace returns a context that is canceled grace after ctx is done, or
+// when the returned cancel function is called
+func withGrace(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	work, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		select {
+		case <-time.After(grace):
+			cancel()
+		case <-work.Done():
+		}
+	})
+	return work, func() {
+		stop()
+		cancel()
+	}
+}
+
+// JobResult is the outcome of a single RunJob cycle
+type JobResult struct {
+	Job  string
+	File string
+	// Key is the storage key of the created backup
+	Key string
+	// Status is success, warning or failure
+	Status     string
+	BackupErr  error
+	CleanupErr error
+}
+
+// Err returns every error of the cycle, or nil when all phases succeeded
+func (r JobResult) Err() error {
+	return errors.Join(r.BackupErr, r.CleanupErr)
+}
+
+// RunJob performs a single backup with its hooks, cleanup and notification
+// cycle for the job. Canceling ctx aborts the backup, the failure is still recorded and notified.
+func RunJob(ctx context.Context, cfg *config.Config, job *config.Job) JobResult {
+	running.Add(1)
+	defer running.Add(-1)
+
+	result := JobResult{Job: job.Name}
+	started := time.Now()
+
+	backup, err := Backup(ctx, cfg, job)
+	report := notify.Report{
+		Job:      job.Name,
+		Host:     job.Postgres.Host,
+		Database: job.Postgres.Dbname,
+		Duration: time.Since(started),
+		Time:     time.Now(),
+	}
+	switch {
+	case err != nil:
+		result.BackupErr = err
+		cfg.Log.Error("❌ Error creating backup", "job", job.Name, "error", err)
+		report.Event = notify.EventFailure
+		report.Error = err.Error()
+	case len(backup.Warnings) > 0:
+		result.File = backup.File
+		addBackupDetails(ctx, cfg, &report, backup)
+		cfg.Log.Warn("⚠️ Backup created with warnings", "job", job.Name, "file", backup.File, "warnings", backup.Warnings)
+		report.Event = notify.EventWarning
+		report.FileName = backup.File
+		report.Warnings = backup.Warnings
+	default:
+		result.File = backup.File
+		addBackupDetails(ctx, cfg, &report, backup)
+		cfg.Log.Info("✅ Backup created successfully", "job", job.Name, "file", backup.File)
+		report.Event = notify.EventSuccess
+		report.FileName = backup.File
+	}
+	notifyAttempts := sendReport(ctx, cfg, job, report)
+
+	// Retention waits for the next run when the backup was aborted
+	var deleted []string
+	var cleanupErr error
+	if ctx.Err() == nil {
+		deleted, cleanupErr = backups.PruneBackups(ctx, cfg, job, false)
+	}
+	result.CleanupErr = cleanupErr
+	result.Status = string(report.Event)
+	record := history.Record{
+		Kind:     history.KindBackup,
+		Job:      job.Name,
+		Status:   string(report.Event),
+		Time:     started,
+		Duration: report.Duration,
+		Error:    report.Error,
+		Deleted:  len(deleted),
+	}
+	record.Attempts = make(map[string]int)
+	if notifyAttempts > 0 {
+		record.Attempts[config.PhaseNotify] = notifyAttempts
+	}
+	var retryErr *retry.Error
+	if backup != nil {
+		record.Key = backup.Entry.Key
+		result.Key = backup.Entry.Key
+		record.Size = backup.Entry.Size
+		maps.Copy(record.Attempts, backup.Attempts)
+	} else if errors.As(err, &retryErr) {
+		record.Attempts[config.PhaseDump] = retryErr.Attempts
+	}
+	if cleanupErr != nil {
+		record.CleanupErr = cleanupErr.Error()
+	}
+	if err := cfg.History.Add(record); err != nil {
+		cfg.Log.Error("Error saving run history", "job", job.Name, "error", err)
+	}
+	observeRun(job, report, backup, len(deleted), cleanupErr)
+
+	if result.CleanupErr != nil {
+		cfg.Log.Error("🚨 Error cleaning up old backups", "job", job.Name, "error", result.CleanupErr)
+		report := report
+		report.Event = notify.EventCleanupError
+		report.Error = result.CleanupErr.Error()
+		report.Time = time.Now()
+		sendReport(ctx, cfg, job, report)
+	} else if ctx.Err() == nil {
+		cfg.Log.Info("🧹 Old backups cleanup completed", "job", job.Name)
+	}
+
+	return result
+}
+
+// addBackupDetails copies what is known about the created backup into the report
+func addBackupDetails(ctx context.Context, cfg *config.Config, report *notify.Report, backup *backups.Result) {
+	report.Size = backup.Entry.Size
+	report.SHA256 = backup.Entry.SHA256
+	report.ServerVersion = backup.Entry.ServerVersion
+	report.Destinations = backup.Destinations
+
+	url, expires, err := backups.DownloadURL(ctx, cfg, backup.Entry)
+	if err != nil {
+		cfg.Log.Warn("⚠️ Failed to create download link", "key", backup.Entry.Key, "error", err)
+		return
+	}
+	report.DownloadURL = url
+	report.DownloadExpires = expires
+}
+
+// sendReport routes the report to the job's notification channels and returns
+// the attempts it took, delivery errors are logged by the dispatcher. Reports
+// are still delivered when the backup was aborted, every attempt is bounded by
+// the notify phase timeout.
+func sendReport(ctx context.Context, cfg *config.Config, job *config.Job, report notify.Report) int {
+	attempts, _ := cfg.Notifier.Dispatch(context.WithoutCancel(ctx), job.Routes, report, job.Backup.Phases.Notify)
+	return attempts
+}
+
 func abs(x float64) float64 {
 	if x < 0 {
 		return -x
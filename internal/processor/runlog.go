@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// maxRunLog is how many log lines a run keeps
+const maxRunLog = 200
+
+// LogLine is a log record written during a run
+type LogLine struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+}
+
+// CaptureLogs returns a logger that also copies every record with a job
+// attribute into the queued or running run of that job
+func (p *Pool) CaptureLogs(log *slog.Logger) *slog.Logger {
+	return slog.New(&runLogHandler{Handler: log.Handler(), pool: p})
+}
+
+// runLogHandler hands records to the pool before passing them on
+type runLogHandler struct {
+	slog.Handler
+	pool *Pool
+	// attrs were added with Logger.With
+	attrs []slog.Attr
+}
+
+func (h *runLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.pool.capture(h.attrs, r)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *runLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &runLogHandler{Handler: h.Handler.WithAttrs(attrs), pool: h.pool, attrs: append(slices.Clip(h.attrs), attrs...)}
+}
+
+func (h *runLogHandler) WithGroup(name string) slog.Handler {
+	return &runLogHandler{Handler: h.Handler.WithGroup(name), pool: h.pool, attrs: h.attrs}
+}
+
+// capture adds the record to the active run of its job
+func (p *Pool) capture(attrs []slog.Attr, r slog.Record) {
+	line := LogLine{Time: r.Time, Level: r.Level.String(), Message: r.Message, Attrs: make(map[string]string)}
+	var job string
+	add := func(a slog.Attr) bool {
+		if a.Key == "job" {
+			job = a.Value.String()
+		} else {
+			line.Attrs[a.Key] = a.Value.String()
+		}
+		return true
+	}
+	for _, a := range attrs {
+		add(a)
+	}
+	r.Attrs(add)
+	if job == "" {
+		return
+	}
+
+	p.mu.Lock()
+	id, ok := p.active[job]
+	p.mu.Unlock()
+	if ok {
+		p.runs.update(id, func(s *RunStatus) {
+			if len(s.Log) < maxRunLog {
+				s.Log = append(s.Log, line)
+			}
+		})
+	}
+}
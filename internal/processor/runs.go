@@ -0,0 +1,134 @@
+package processor
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// What started a run
+const (
+	TriggerSchedule = "schedule"
+	TriggerAPI      = "api"
+	TriggerSignal   = "signal"
+	TriggerCLI      = "cli"
+)
+
+// Statuses of a run that has not finished, a finished run has the status of
+// its history record: success, warning or failure
+const (
+	StatusQueued   = "queued"
+	StatusRunning  = "running"
+	StatusCanceled = "canceled"
+)
+
+// maxRuns is how many runs a pool remembers for Status
+const maxRuns = 100
+
+var (
+	// ErrAlreadyQueued is returned when a run of the job is already queued or running
+	ErrAlreadyQueued = errors.New("a run of the job is already queued or running")
+	// ErrShuttingDown is returned when the pool no longer starts jobs
+	ErrShuttingDown = errors.New("shutting down, no new runs are started")
+)
+
+// RunStatus is the state of a run submitted to a Pool
+type RunStatus struct {
+	ID         string     `json:"id"`
+	Job        string     `json:"job"`
+	Trigger    string     `json:"trigger"`
+	Status     string     `json:"status"`
+	QueuedAt   time.Time  `json:"queued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Key is the storage key of the created backup
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Log holds the first log lines of the run
+	Log []LogLine `json:"log,omitempty"`
+}
+
+// Done reports whether the run has finished or was canceled
+func (s RunStatus) Done() bool {
+	return s.Status != StatusQueued && s.Status != StatusRunning
+}
+
+// runs remembers the most recent runs of a pool
+type runs struct {
+	mu    sync.Mutex
+	byID  map[string]*RunStatus
+	order []string
+}
+
+func (r *runs) add(job, trigger string) RunStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := &RunStatus{ID: uuid.NewString(), Job: job, Trigger: trigger, Status: StatusQueued, QueuedAt: time.Now()}
+	r.byID[s.ID] = s
+	r.order = append(r.order, s.ID)
+	if len(r.order) > maxRuns {
+		delete(r.byID, r.order[0])
+		r.order = r.order[1:]
+	}
+	return *s
+}
+
+func (r *runs) get(id string) (RunStatus, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.byID[id]
+	if !ok {
+		return RunStatus{}, false
+	}
+	status := *s
+	status.Log = slices.Clone(s.Log)
+	return status, true
+}
+
+// list returns the runs newest first, without their logs
+func (r *runs) list() []RunStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]RunStatus, 0, len(r.order))
+	for i := len(r.order) - 1; i >= 0; i-- {
+		status := *r.byID[r.order[i]]
+		status.Log = nil
+		result = append(result, status)
+	}
+	return result
+}
+
+func (r *runs) update(id string, fn func(s *RunStatus)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.byID[id]; ok {
+		fn(s)
+	}
+}
+
+func (r *runs) start(id string) {
+	r.update(id, func(s *RunStatus) {
+		now := time.Now()
+		s.Status, s.StartedAt = StatusRunning, &now
+	})
+}
+
+func (r *runs) cancel(id string) {
+	r.update(id, func(s *RunStatus) {
+		now := time.Now()
+		s.Status, s.FinishedAt = StatusCanceled, &now
+	})
+}
+
+func (r *runs) finish(id string, result JobResult) {
+	r.update(id, func(s *RunStatus) {
+		now := time.Now()
+		s.Status, s.FinishedAt, s.Key = result.Status, &now, result.Key
+		if err := result.Err(); err != nil {
+			s.Error = err.Error()
+		}
+	})
+}
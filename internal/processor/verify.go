@@ -0,0 +1,36 @@
+package processor
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/history"
+	"PostgresDump/internal/services/backups"
+	"context"
+	"time"
+)
+
+// Verify checks a backup and records the result in the run history for
+// digests and in the metrics
+func Verify(ctx context.Context, cfg *config.Config, entry catalog.Entry) error {
+	started := time.Now()
+	verifyErr := backups.VerifyBackup(ctx, cfg, entry)
+
+	ObserveVerify(entry, started, verifyErr)
+	record := history.Record{
+		Kind:     history.KindVerify,
+		Job:      entry.Job,
+		Status:   history.StatusSuccess,
+		Time:     started,
+		Duration: time.Since(started),
+		Key:      entry.Key,
+		Size:     entry.Size,
+	}
+	if verifyErr != nil {
+		record.Status = history.StatusFailure
+		record.Error = verifyErr.Error()
+	}
+	if err := cfg.History.Add(record); err != nil {
+		cfg.Log.Error("Error saving run history", "job", entry.Job, "error", err)
+	}
+	return verifyErr
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"PostgresDump/internal/processor"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+	// Run is the run already queued or running when a trigger was refused
+	Run *processor.RunStatus `json:"run,omitempty"`
+}
+
+// Scopes of the API tokens, the admin scope includes the read-only one
+const (
+	scopeRead  = "read"
+	scopeAdmin = "admin"
+)
+
+// authorized requires a bearer token with the scope
+func (s *Server) authorized(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var granted string
+		switch {
+		case !ok:
+		case tokenEqual(token, s.cfg.HTTP.APIToken):
+			granted = scopeAdmin
+		case tokenEqual(token, s.cfg.HTTP.APIReadToken):
+			granted = scopeRead
+		}
+		if granted == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="pgsnapsafe"`)
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "missing or invalid bearer token"})
+			return
+		}
+		if scope == scopeAdmin && granted != scopeAdmin {
+			writeJSON(w, http.StatusForbidden, errorResponse{Error: "the token is read-only"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func tokenEqual(token, expected string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// triggerRun queues a run of the job and responds with its ID, the run's
+// status can be polled at the Location
+func (s *Server) triggerRun(w http.ResponseWriter, r *http.Request) {
+	job, err := s.cfg.Job(r.PathValue("job"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+
+	status, err := s.pool.Submit(job, processor.TriggerAPI, nil)
+	switch {
+	case errors.Is(err, processor.ErrAlreadyQueued):
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error(), Run: &status})
+		return
+	case err != nil:
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+		return
+	}
+
+	s.cfg.Log.Info("▶️ On-demand backup queued", "job", job.Name, "run", status.ID, "remote", r.RemoteAddr)
+	w.Header().Set("Location", "/api/v1/runs/"+status.ID)
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// runStatus responds with the state of a run
+func (s *Server) runStatus(w http.ResponseWriter, r *http.Request) {
+	status, ok := s.pool.Status(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "run not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
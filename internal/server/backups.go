@@ -0,0 +1,217 @@
+package server
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/history"
+	"PostgresDump/internal/processor"
+	"PostgresDump/internal/services/backups"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+// maxPresignTTL is the longest validity of an S3 presigned URL
+const maxPresignTTL = 7 * 24 * time.Hour
+
+type presignResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// listBackups responds with the cataloged backups, newest first. The job,
+// database, since, until and q query parameters filter them like catalog list,
+// pinned=true only lists pinned backups.
+func (s *Server) listBackups(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := catalog.Filter{Job: query.Get("job"), Database: query.Get("database"), Query: query.Get("q")}
+	filter.Pinned, _ = strconv.ParseBool(query.Get("pinned"))
+	var err error
+	if filter.Since, err = catalog.ParseDate(query.Get("since")); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid since: %v", err)})
+		return
+	}
+	if filter.Until, err = catalog.ParseDate(query.Get("until")); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid until: %v", err)})
+		return
+	}
+
+	entries := s.cfg.Catalog.List(filter)
+	if entries == nil {
+		entries = []catalog.Entry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// getBackup responds with the catalog entry of a backup
+func (s *Server) getBackup(w http.ResponseWriter, r *http.Request) {
+	if entry, ok := s.entry(w, r); ok {
+		writeJSON(w, http.StatusOK, entry)
+	}
+}
+
+// downloadBackup streams a backup as it is stored
+func (s *Server) downloadBackup(w http.ResponseWriter, r *http.Request) {
+	entry, ok := s.entry(w, r)
+	if !ok {
+		return
+	}
+	body, size, err := backups.OpenBackup(r.Context(), s.cfg, entry)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(entry.Key)))
+	if size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if entry.SHA256 != "" {
+		w.Header().Set("X-Checksum-Sha256", entry.SHA256)
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		s.cfg.Log.Warn("⚠️ Backup download interrupted", "backup", entry.ID, "error", err)
+	}
+}
+
+// presignBackup responds with a presigned S3 URL of a backup, valid for the
+// ttl query parameter or download_link_ttl
+func (s *Server) presignBackup(w http.ResponseWriter, r *http.Request) {
+	entry, ok := s.entry(w, r)
+	if !ok {
+		return
+	}
+	ttl := s.cfg.DownloadLinkTTL
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil || ttl <= 0 || ttl > maxPresignTTL {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "ttl must be a duration between 1s and 168h"})
+			return
+		}
+	}
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	if entry.Location != catalog.LocationS3 {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: "only backups stored in S3 can be presigned, use /download"})
+		return
+	}
+
+	url, expires, err := backups.PresignURL(r.Context(), s.cfg, entry, ttl)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, presignResponse{URL: url, ExpiresAt: expires})
+}
+
+type verifyResponse struct {
+	Backup   string  `json:"backup"`
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// verifyBackup checks that a backup is complete and readable like the verify
+// command and records the result, it responds when the check is done
+func (s *Server) verifyBackup(w http.ResponseWriter, r *http.Request) {
+	entry, ok := s.entry(w, r)
+	if !ok {
+		return
+	}
+	started := time.Now()
+	resp := verifyResponse{Backup: entry.ID, Status: history.StatusSuccess}
+	if err := processor.Verify(r.Context(), s.cfg, entry); err != nil {
+		resp.Status, resp.Error = history.StatusFailure, err.Error()
+	}
+	resp.Duration = time.Since(started).Seconds()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type pinRequest struct {
+	Reason string `json:"reason"`
+	Until  string `json:"until"`
+}
+
+// pinBackup pins or unpins a backup against retention. A pin takes an
+// optional JSON body with a reason and an until date.
+func (s *Server) pinBackup(pinned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry, ok := s.entry(w, r)
+		if !ok {
+			return
+		}
+		if !pinned {
+			entry, err := backups.UnpinBackup(r.Context(), s.cfg, entry)
+			if err != nil {
+				writeJSON(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+				return
+			}
+			s.cfg.Log.Info("📌 Backup unpinned", "backup", entry.ID, "remote", r.RemoteAddr)
+			writeJSON(w, http.StatusOK, entry)
+			return
+		}
+
+		var req pinRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid request body: %v", err)})
+			return
+		}
+		until, err := catalog.ParseDate(req.Until)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid until: %v", err)})
+			return
+		}
+		if !until.IsZero() && !until.After(time.Now()) {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "until must be in the future"})
+			return
+		}
+		entry, err = backups.PinBackup(r.Context(), s.cfg, entry, req.Reason, until)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+			return
+		}
+		s.cfg.Log.Info("📌 Backup pinned", "backup", entry.ID, "reason", req.Reason, "until", req.Until, "remote", r.RemoteAddr)
+		writeJSON(w, http.StatusOK, entry)
+	}
+}
+
+// deleteBackup deletes a backup from storage and the catalog, pinned backups
+// have to be unpinned first
+func (s *Server) deleteBackup(w http.ResponseWriter, r *http.Request) {
+	entry, ok := s.entry(w, r)
+	if !ok {
+		return
+	}
+	if entry.PinActive(time.Now()) {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: "the backup is pinned, unpin it first"})
+		return
+	}
+	if err := backups.DeleteBackup(r.Context(), s.cfg, entry); err != nil {
+		writeJSON(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+		return
+	}
+	s.cfg.Log.Info("🗑️ Backup deleted through the API", "backup", entry.ID, "remote", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// entry looks up the backup of the id path value, an unambiguous ID prefix is
+// accepted. It writes the error response when there is no such backup.
+func (s *Server) entry(w http.ResponseWriter, r *http.Request) (catalog.Entry, bool) {
+	entry, err := s.cfg.Catalog.Get(r.PathValue("id"))
+	switch {
+	case errors.Is(err, catalog.ErrNotFound):
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return catalog.Entry{}, false
+	case err != nil:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return catalog.Entry{}, false
+	}
+	return entry, true
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"PostgresDump/internal/processor"
+	healthcheck "PostgresDump/internal/services/healthCheck"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxHeartbeatAge is how long the scheduler may go without checking the
+// schedule; it checks every minute
+const maxHeartbeatAge = 3 * time.Minute
+
+// runSlack is added to the longest a run can take in its phases and hooks,
+// for checksums, manifests and retention
+const runSlack = 30 * time.Minute
+
+// maxUnboundedRun is how long a run of a job whose dump or upload phase has no
+// timeout may take before it is considered hung
+const maxUnboundedRun = 24 * time.Hour
+
+// readyTimeout bounds all readiness checks together
+const readyTimeout = 10 * time.Second
+
+type healthResponse struct {
+	Status string              `json:"status"`
+	Checks []healthcheck.Check `json:"checks"`
+}
+
+// healthz reports whether the process is alive, the scheduler loop is ticking
+// and no backup has been running for longer than it possibly can
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	check := healthcheck.Check{Name: "scheduler", OK: true}
+	beat := processor.Heartbeat()
+	switch {
+	case beat.IsZero():
+		if time.Since(s.started) > maxHeartbeatAge {
+			check.OK, check.Error = false, "scheduler has not started"
+		}
+	case time.Since(beat) > maxHeartbeatAge:
+		check.OK = false
+		check.Error = fmt.Sprintf("scheduler last ticked %s ago", time.Since(beat).Round(time.Second))
+	}
+	writeHealth(w, []healthcheck.Check{check, s.runsCheck()})
+}
+
+// runsCheck fails when a run takes longer than its phase and hook timeouts
+// allow, e.g. because pg_dump hangs in a job without a dump timeout
+func (s *Server) runsCheck() healthcheck.Check {
+	check := healthcheck.Check{Name: "runs", OK: true}
+	var hung []string
+	for _, run := range s.pool.Runs() {
+		if run.Status != processor.StatusRunning || run.StartedAt == nil {
+			continue
+		}
+		limit := maxUnboundedRun
+		if job, err := s.cfg.Job(run.Job); err == nil && job.Backup.MaxRunDuration() > 0 {
+			limit = job.Backup.MaxRunDuration() + runSlack
+		}
+		if elapsed := time.Since(*run.StartedAt); elapsed > limit {
+			hung = append(hung, fmt.Sprintf("run %s of job %s has been running for %s, longer than %s",
+				run.ID, run.Job, elapsed.Round(time.Second), limit))
+		}
+	}
+	if len(hung) > 0 {
+		check.OK, check.Error = false, strings.Join(hung, "; ")
+	}
+	return check
+}
+
+// readyz reports whether backups can be made and are recent enough
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	writeHealth(w, healthcheck.Readiness(ctx, s.cfg, s.started))
+}
+
+// writeHealth responds 200 when every check passed and 503 otherwise
+func writeHealth(w http.ResponseWriter, checks []healthcheck.Check) {
+	resp := healthResponse{Status: "ok", Checks: checks}
+	code := http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			resp.Status = "fail"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, code, resp)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/history"
+	"PostgresDump/internal/processor"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// maxHistoryLimit bounds the records returned by /api/v1/history
+const maxHistoryLimit = 1000
+
+type jobResponse struct {
+	Name       string     `json:"name"`
+	Cluster    string     `json:"cluster"`
+	Host       string     `json:"host"`
+	Database   string     `json:"database"`
+	Times      []string   `json:"times"`
+	NextRun    *time.Time `json:"next_run,omitempty"`
+	KeepCopies int        `json:"keep_copies"`
+	// Leader is set with leader election and tells whether this instance runs the job
+	Leader *bool `json:"leader,omitempty"`
+	// Run is the queued or running run of the job
+	Run *processor.RunStatus `json:"run,omitempty"`
+}
+
+// listJobs responds with the configured jobs, their schedule and active run
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	jobs := make([]jobResponse, 0, len(s.cfg.Jobs))
+	for _, job := range s.cfg.Jobs {
+		resp := jobResponse{
+			Name:       job.Name,
+			Cluster:    job.Backup.Cluster,
+			Host:       job.Postgres.Host,
+			Database:   job.Postgres.Dbname,
+			Times:      job.Backup.Times,
+			KeepCopies: job.Backup.KeepCopies,
+		}
+		if next, ok := processor.NextRun(job, now); ok {
+			resp.NextRun = &next
+		}
+		if s.cfg.Leader != nil {
+			leads := s.cfg.Leads(config.JobLease(job))
+			resp.Leader = &leads
+		}
+		if run, ok := s.pool.Active(job.Name); ok {
+			run.Log = nil
+			resp.Run = &run
+		}
+		jobs = append(jobs, resp)
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// listRuns responds with the recent runs of this process, newest first
+func (s *Server) listRuns(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.pool.Runs())
+}
+
+// listHistory responds with the recorded backup and verification runs, newest
+// first. The job and kind query parameters filter them, limit caps their number.
+func (s *Server) listHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 50
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxHistoryLimit {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "limit must be between 1 and 1000"})
+			return
+		}
+		limit = n
+	}
+
+	records := s.cfg.History.Between(query.Get("job"), time.Time{}, time.Now().Add(time.Second))
+	slices.Reverse(records)
+	result := make([]history.Record, 0, min(limit, len(records)))
+	for _, rec := range records {
+		if kind := query.Get("kind"); kind != "" && rec.Kind != kind {
+			continue
+		}
+		if len(result) == limit {
+			break
+		}
+		result = append(result, rec)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"PostgresDump/internal/config"
+	"PostgresDump/internal/processor"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Server is the HTTP server of the daemon
+type Server struct {
+	cfg  *config.Config
+	pool *processor.Pool
+	mux  *http.ServeMux
+	srv  *http.Server
+	// started is when the server was created, the grace period of the probes starts then
+	started time.Time
+}
+
+// New creates the server with its routes, it doesn't listen until Start. The
+// API and the dashboard are only served with an API token, on-demand runs are
+// queued in pool.
+func New(cfg *config.Config, pool *processor.Pool) *Server {
+	s := &Server{cfg: cfg, pool: pool, mux: http.NewServeMux(), started: time.Now()}
+	s.mux.Handle("GET /metrics", processor.Metrics.Handler())
+	s.mux.HandleFunc("GET /healthz", s.healthz)
+	s.mux.HandleFunc("GET /readyz", s.readyz)
+	if cfg.HTTP.APIToken != "" || cfg.HTTP.APIReadToken != "" {
+		s.mux.HandleFunc("POST /api/v1/jobs/{job}/runs", s.authorized(scopeAdmin, s.triggerRun))
+		s.mux.HandleFunc("GET /api/v1/runs", s.authorized(scopeRead, s.listRuns))
+		s.mux.HandleFunc("GET /api/v1/runs/{id}", s.authorized(scopeRead, s.runStatus))
+		s.mux.HandleFunc("GET /api/v1/jobs", s.authorized(scopeRead, s.listJobs))
+		s.mux.HandleFunc("GET /api/v1/history", s.authorized(scopeRead, s.listHistory))
+		s.mux.HandleFunc("GET /api/v1/backups", s.authorized(scopeRead, s.listBackups))
+		s.mux.HandleFunc("GET /api/v1/backups/{id}", s.authorized(scopeRead, s.getBackup))
+		s.mux.HandleFunc("GET /api/v1/backups/{id}/download", s.authorized(scopeRead, s.downloadBackup))
+		s.mux.HandleFunc("POST /api/v1/backups/{id}/presign", s.authorized(scopeRead, s.presignBackup))
+		s.mux.HandleFunc("POST /api/v1/backups/{id}/verify", s.authorized(scopeAdmin, s.verifyBackup))
+		s.mux.HandleFunc("PUT /api/v1/backups/{id}/pin", s.authorized(scopeAdmin, s.pinBackup(true)))
+		s.mux.HandleFunc("DELETE /api/v1/backups/{id}/pin", s.authorized(scopeAdmin, s.pinBackup(false)))
+		s.mux.HandleFunc("DELETE /api/v1/backups/{id}", s.authorized(scopeAdmin, s.deleteBackup))
+		s.mux.Handle("GET /ui/", dashboard())
+		s.mux.Handle("GET /{$}", http.RedirectHandler("/ui/", http.StatusFound))
+	}
+
+	s.srv = &http.Server{
+		Addr:              cfg.HTTP.Listen,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Start listens in the background, errors other than a shutdown are logged
+func (s *Server) Start() {
+	s.cfg.Log.Info("🌐 Starting HTTP server", "listen", s.srv.Addr)
+	go func() {
+		if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.cfg.Log.Error("❌ HTTP server failed", "error", err)
+		}
+	}()
+}
+
+// Shutdown stops the server, waiting for active requests until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
@@ -0,0 +1,241 @@
+// Dashboard of the pgsnapsafe HTTP API. The token is kept in sessionStorage
+// and sent as a bearer token, the page itself holds no data.
+"use strict";
+
+const api = "../api/v1";
+const refreshInterval = 10000;
+let token = sessionStorage.getItem("pgsnapsafe-token") || "";
+let shownLog = "";
+
+const $ = (id) => document.getElementById(id);
+
+async function request(method, path) {
+  const resp = await fetch(api + path, {method, headers: {Authorization: "Bearer " + token}});
+  if (resp.status === 401) {
+    signOut();
+    throw new Error("The token was rejected");
+  }
+  const body = resp.status === 204 ? null : await resp.json();
+  if (!resp.ok) {
+    throw new Error(body && body.error ? body.error : resp.statusText);
+  }
+  return body;
+}
+
+function showError(err) {
+  $("error").textContent = err ? err.message : "";
+  $("error").hidden = !err;
+}
+
+function formatTime(value) {
+  return value ? new Date(value).toLocaleString() : "";
+}
+
+function formatSize(bytes) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return (i === 0 ? bytes : bytes.toFixed(1)) + " " + units[i];
+}
+
+function formatDuration(seconds) {
+  if (seconds < 60) {
+    return seconds.toFixed(1) + "s";
+  }
+  return Math.floor(seconds / 60) + "m " + Math.round(seconds % 60) + "s";
+}
+
+// row appends a table row, cells are strings or nodes
+function row(tbody, cells) {
+  const tr = document.createElement("tr");
+  for (const cell of cells) {
+    const td = document.createElement("td");
+    if (cell instanceof Node) {
+      td.appendChild(cell);
+    } else {
+      td.textContent = cell == null ? "" : cell;
+    }
+    tr.appendChild(td);
+  }
+  tbody.appendChild(tr);
+  return tr;
+}
+
+function status(value) {
+  const span = document.createElement("span");
+  span.className = "status-" + value;
+  span.textContent = value;
+  return span;
+}
+
+function button(label, onClick) {
+  const b = document.createElement("button");
+  b.textContent = label;
+  b.addEventListener("click", async () => {
+    b.disabled = true;
+    try {
+      await onClick();
+      showError(null);
+    } catch (err) {
+      showError(err);
+    } finally {
+      b.disabled = false;
+      refresh();
+    }
+  });
+  return b;
+}
+
+function buttons(...list) {
+  const span = document.createElement("span");
+  for (const b of list) {
+    span.appendChild(b);
+    span.appendChild(document.createTextNode(" "));
+  }
+  return span;
+}
+
+function renderJobs(jobs, backups) {
+  const tbody = $("jobs");
+  tbody.replaceChildren();
+  for (const job of jobs) {
+    const current = job.run ? status(job.run.status) : document.createTextNode(job.leader === false ? "follower" : "");
+    const latest = backups.find((b) => b.job === job.name);
+    const actions = [button("Back up now", () => request("POST", "/jobs/" + encodeURIComponent(job.name) + "/runs"))];
+    if (latest) {
+      actions.push(button("Verify latest", () => verify(latest)));
+    }
+    row(tbody, [job.name, job.database + "@" + job.host, job.times.join(", "), formatTime(job.next_run), current, buttons(...actions)]);
+  }
+}
+
+function renderRuns(runs) {
+  const tbody = $("runs");
+  tbody.replaceChildren();
+  for (const run of runs) {
+    row(tbody, [formatTime(run.queued_at), run.job, run.trigger, status(run.status), run.error || run.key,
+      button("Log", () => showLog(run.id))]).cells[4].className = "wrap";
+  }
+  if (shownLog) {
+    showLog(shownLog).catch(showError);
+  }
+}
+
+async function showLog(id) {
+  const run = await request("GET", "/runs/" + id);
+  shownLog = id;
+  $("log").hidden = false;
+  $("log-title").textContent = "Log of " + run.job + " (" + run.status + ")";
+  $("log-lines").textContent = (run.log || []).map((line) => {
+    const attrs = Object.entries(line.attrs || {}).map(([k, v]) => k + "=" + v).join(" ");
+    return new Date(line.time).toLocaleTimeString() + " " + line.level + " " + line.message + " " + attrs;
+  }).join("\n") || "No log lines";
+}
+
+function renderHistory(records) {
+  const tbody = $("history");
+  tbody.replaceChildren();
+  for (const r of records) {
+    row(tbody, [formatTime(r.time), r.kind, r.job, status(r.status), formatDuration(r.duration / 1e9),
+      r.size ? formatSize(r.size) : "", r.error || r.key]).cells[6].className = "wrap";
+  }
+}
+
+function renderBackups(backups) {
+  const totals = {};
+  for (const b of backups) {
+    const t = totals[b.location] || (totals[b.location] = {count: 0, size: 0});
+    t.count++;
+    t.size += b.size;
+  }
+  $("destinations").replaceChildren(...Object.entries(totals).map(([location, t]) => {
+    const div = document.createElement("div");
+    div.textContent = location + ": " + t.count + " backups, " + formatSize(t.size);
+    return div;
+  }));
+
+  const tbody = $("backups");
+  tbody.replaceChildren();
+  for (const b of backups) {
+    const actions = [button("Verify", () => verify(b)), button("Download", () => download(b))];
+    const tr = row(tbody, [formatTime(b.created_at), b.job, b.location + (b.pinned ? " 📌" : ""), formatSize(b.size), b.key,
+      buttons(...actions)]);
+    tr.cells[4].className = "wrap";
+    if (b.pinned) {
+      tr.cells[2].title = "Pinned " + (b.pinned_until ? "until " + formatTime(b.pinned_until) : "forever") +
+        (b.pin_reason ? ": " + b.pin_reason : "");
+    }
+  }
+}
+
+async function verify(backup) {
+  const result = await request("POST", "/backups/" + backup.id + "/verify");
+  if (result.status !== "success") {
+    throw new Error("Verification of " + backup.key + " failed: " + result.error);
+  }
+}
+
+// download opens a presigned URL for S3 backups and fetches local ones with the token
+async function download(backup) {
+  if (backup.location === "s3") {
+    const link = await request("POST", "/backups/" + backup.id + "/presign");
+    window.location.href = link.url;
+    return;
+  }
+  const resp = await fetch(api + "/backups/" + backup.id + "/download", {headers: {Authorization: "Bearer " + token}});
+  if (!resp.ok) {
+    throw new Error((await resp.json()).error);
+  }
+  const a = document.createElement("a");
+  a.href = URL.createObjectURL(await resp.blob());
+  a.download = backup.key.split("/").pop();
+  a.click();
+  URL.revokeObjectURL(a.href);
+}
+
+async function refresh() {
+  if (!token) {
+    return;
+  }
+  try {
+    const [jobs, runs, records, backups] = await Promise.all([
+      request("GET", "/jobs"), request("GET", "/runs"), request("GET", "/history?limit=50"), request("GET", "/backups"),
+    ]);
+    renderJobs(jobs, backups);
+    renderRuns(runs);
+    renderHistory(records);
+    renderBackups(backups);
+    $("updated").textContent = "Updated " + new Date().toLocaleTimeString();
+  } catch (err) {
+    showError(err);
+  }
+}
+
+function signOut() {
+  token = "";
+  sessionStorage.removeItem("pgsnapsafe-token");
+  show();
+}
+
+function show() {
+  $("login").hidden = !!token;
+  $("dashboard").hidden = !token;
+  $("signout").hidden = !token;
+}
+
+$("login").addEventListener("submit", (e) => {
+  e.preventDefault();
+  token = $("token").value;
+  sessionStorage.setItem("pgsnapsafe-token", token);
+  showError(null);
+  show();
+  refresh();
+});
+$("signout").addEventListener("click", signOut);
+
+show();
+refresh();
+setInterval(refresh, refreshInterval);
@@ -1,65 +1,277 @@
 package backups
 
 import (
+	"PostgresDump/internal/catalog"
 	"PostgresDump/internal/config"
+	"PostgresDump/pkg/retry"
 	"PostgresDump/pkg/stree"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
+	_ "github.com/lib/pq"
+	"io"
+	"io/fs"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
-func CreateBackup(cfg *config.Config) (string, error) {
-	cfg.Log.Info("🚀 Starting backup creation...")
+// Result describes a created backup
+type Result struct {
+	// File is the S3 key of the backup, or its local path when it is kept locally
+	File     string
+	Entry    catalog.Entry
+	Duration time.Duration
+	// Destinations lists where the backup is stored, as local paths or s3:// URLs
+	Destinations []string
+	// Warnings lists problems that didn't prevent the backup, such as a failed upload
+	Warnings []string
+	// Attempts counts the attempts of the dump and upload phases
+	Attempts map[string]int
+}
+
+// PartialSuffix marks a dump that is still being written, it is renamed once pg_dump succeeds
+const PartialSuffix = ".partial"
+
+// CreateBackup dumps the job's database, or copies its cluster with
+// pg_basebackup for physical jobs, and stores the backup, retrying the dump
+// and upload phases as configured. When ctx is canceled the client program is
+// interrupted and the partially written files are removed.
+func CreateBackup(ctx context.Context, cfg *config.Config, job *config.Job) (*Result, error) {
+	cfg.Log.Info("🚀 Starting backup creation...", "job", job.Name)
 
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	fileName := fmt.Sprintf("postgres_backup_%s.dump", timestamp)
-	filePath := fmt.Sprintf("%s/%s", cfg.Postgres.BackupPath, fileName)
+	createdAt := time.Now()
+	objectKey := job.Backup.Key.Render(job.KeyVars(createdAt))
+	filePath := localPath(cfg, objectKey)
 
-	if _, err := os.Stat(cfg.Postgres.BackupPath); os.IsNotExist(err) {
-		cfg.Log.Warn("📂 Backup directory doesn't exist, creating...", "path", cfg.Postgres.BackupPath)
-		err := os.MkdirAll(cfg.Postgres.BackupPath, 0755)
+	if _, err := os.Stat(filepath.Dir(filePath)); os.IsNotExist(err) {
+		cfg.Log.Warn("📂 Backup directory doesn't exist, creating...", "path", filepath.Dir(filePath))
+		err := os.MkdirAll(filepath.Dir(filePath), 0755)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 	}
 
-	err := os.Setenv("PGPASSWORD", cfg.Postgres.Password)
+	var err error
+	var wal walRange
+	attempts := make(map[string]int)
+	attempts[config.PhaseDump], err = job.Backup.Phases.Dump.Do(ctx, func(ctx context.Context) error {
+		if job.Backup.Type == config.BackupPhysical {
+			var err error
+			wal, err = basebackup(ctx, cfg, job, filePath)
+			return err
+		}
+		return dump(ctx, cfg, job, filePath)
+	}, func(err error, wait time.Duration) {
+		cfg.Log.Warn("🔁 Backup failed, retrying", "job", job.Name, "wait", wait.Round(time.Second).String(), "error", err)
+	})
 	if err != nil {
-		return "", err
+		if ctx.Err() != nil {
+			return nil, &retry.Error{Attempts: attempts[config.PhaseDump], Err: fmt.Errorf("❌ Backup aborted: %w", ctx.Err())}
+		}
+		return nil, fmt.Errorf("❌ Error creating backup: %w", err)
 	}
 
-	cmd := exec.Command(
-		"pg_dump",
-		"-U", cfg.Postgres.User,
-		"-h", cfg.Postgres.Host,
-		"-p", cfg.Postgres.Port,
-		"-F", "c",
-		"-f", filePath,
-		cfg.Postgres.Dbname,
-	)
-	cmd.Env = append(os.Environ(), "PGPASSWORD="+cfg.Postgres.Password)
+	var warnings []string
+	if job.Backup.Type == config.BackupPhysical && !wal.complete() {
+		warnings = append(warnings, "the WAL range of the base backup is unknown, it isn't recorded in the catalog")
+	}
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("❌ Error creating backup: %v\n%s", err, string(output))
+	entry := newEntry(job, objectKey, createdAt)
+	entry.StartLSN, entry.StopLSN, entry.Timeline = wal.StartLSN, wal.StopLSN, wal.Timeline
+	if info, err := os.Stat(filePath); err == nil {
+		entry.Size = info.Size()
+	}
+	if entry.SHA256, err = fileSHA256(filePath); err != nil {
+		cfg.Log.Warn("⚠️ Failed to compute backup checksum", "file", filePath, "error", err)
+	}
+	if entry.ServerVersion, err = serverVersion(ctx, job); err != nil {
+		cfg.Log.Warn("⚠️ Failed to read PostgreSQL server version", "job", job.Name, "error", err)
+	}
+
+	manifestPath := filePath + ManifestSuffix
+	if err := writeManifest(manifestPath, newManifest(entry)); err != nil {
+		cfg.Log.Warn("⚠️ Failed to write backup manifest", "file", manifestPath, "error", err)
 	}
 
 	if cfg.S3Client != nil {
 
-		fileName, err := stree.UploadFileToS3(cfg.S3Client, cfg.BucketName, filePath, cfg.Postgres.BackupPath)
+		upload := job.Backup.Phases.Upload
+		retrying := func(err error, wait time.Duration) {
+			cfg.Log.Warn("🔁 Upload to S3 failed, retrying", "job", job.Name, "wait", wait.Round(time.Second).String(), "error", err)
+		}
+
+		var fileName string
+		attempts[config.PhaseUpload], err = upload.Do(ctx, func(ctx context.Context) error {
+			var err error
+			fileName, err = stree.UploadFileToS3(ctx, cfg.S3Client, cfg.BucketName, filePath, objectKey, entryMetadata(entry))
+			return err
+		}, retrying)
 		if err != nil {
 			cfg.Log.Error("❌ Error uploading to S3", "error", err)
-			return filePath, nil
+			entry = localEntry(entry, filePath)
+			recordEntry(cfg, entry)
+			return &Result{
+				File:         filePath,
+				Entry:        entry,
+				Duration:     time.Since(createdAt),
+				Destinations: []string{filePath},
+				Warnings:     append(warnings, fmt.Sprintf("upload to S3 failed, the backup is kept locally: %v", err)),
+				Attempts:     attempts,
+			}, nil
 		}
 
-		err = os.Remove(filePath)
+		_, err = upload.Do(ctx, func(ctx context.Context) error {
+			_, err := stree.UploadFileToS3(ctx, cfg.S3Client, cfg.BucketName, manifestPath, manifestKey(objectKey), nil)
+			return err
+		}, retrying)
 		if err != nil {
-			cfg.Log.Warn("⚠️ Failed to delete local file", "file", filePath, "error", err)
+			cfg.Log.Warn("⚠️ Error uploading backup manifest to S3", "error", err)
+		}
+
+		for _, file := range []string{filePath, manifestPath} {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				cfg.Log.Warn("⚠️ Failed to delete local file", "file", file, "error", err)
+			}
+		}
+
+		entry = s3Entry(entry, cfg.BucketName)
+		recordEntry(cfg, entry)
+		return &Result{
+			File:         fileName,
+			Entry:        entry,
+			Duration:     time.Since(createdAt),
+			Destinations: []string{fmt.Sprintf("s3://%s/%s", cfg.BucketName, objectKey)},
+			Warnings:     warnings,
+			Attempts:     attempts,
+		}, nil
+	}
+
+	entry = localEntry(entry, filePath)
+	recordEntry(cfg, entry)
+	return &Result{File: filePath, Entry: entry, Duration: time.Since(createdAt), Destinations: []string{filePath}, Warnings: warnings, Attempts: attempts}, nil
+}
+
+// dump runs pg_dump into a partial file and renames it to filePath once it succeeded
+func dump(ctx context.Context, cfg *config.Config, job *config.Job, filePath string) error {
+	pgDump, err := ClientBinary(ctx, cfg, job, "pg_dump")
+	if err != nil {
+		return fmt.Errorf("failed to select pg_dump: %w", err)
+	}
+	cfg.Log.Info("🧰 Using pg_dump", "job", job.Name, "version", pgDump.Version, "path", pgDump.Path)
+
+	partialPath := filePath + PartialSuffix
+	cmd := command(ctx, pgDump.Path,
+		"-U", job.Postgres.User,
+		"-h", job.Postgres.Host,
+		"-p", job.Postgres.Port,
+		"-F", "c",
+		"-f", partialPath,
+		job.Postgres.Dbname,
+	)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+job.Postgres.Password)
+
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		err = os.Rename(partialPath, filePath)
+	}
+	if err != nil {
+		if err := os.Remove(partialPath); err != nil && !os.IsNotExist(err) {
+			cfg.Log.Warn("⚠️ Failed to delete partial backup", "file", partialPath, "error", err)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("pg_dump interrupted: %w", ctx.Err())
 		}
+		return fmt.Errorf("%v\n%s", err, string(output))
+	}
+	return nil
+}
 
-		return fileName, nil
+// DownloadURL returns a presigned link to an S3 backup and when it expires. It
+// returns an empty link for local backups or when links are disabled.
+func DownloadURL(ctx context.Context, cfg *config.Config, entry catalog.Entry) (string, time.Time, error) {
+	if entry.Location != catalog.LocationS3 || cfg.S3Client == nil || cfg.DownloadLinkTTL <= 0 {
+		return "", time.Time{}, nil
 	}
+	return PresignURL(ctx, cfg, entry, cfg.DownloadLinkTTL)
+}
 
-	return filePath, nil
+// PresignURL returns a link that downloads a backup stored in S3 without credentials until it expires
+func PresignURL(ctx context.Context, cfg *config.Config, entry catalog.Entry, ttl time.Duration) (string, time.Time, error) {
+	if entry.Location != catalog.LocationS3 || cfg.S3Client == nil {
+		return "", time.Time{}, fmt.Errorf("backup %s is not stored in S3", entry.ID)
+	}
+	expires := time.Now().Add(ttl)
+	url, err := stree.PresignDownloadURL(ctx, cfg.S3Client, entry.Bucket, entry.Key, ttl)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return url, expires, nil
+}
+
+// fileSHA256 returns the hex SHA-256 checksum of a file
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// serverVersion asks the job's PostgreSQL server for its version
+func serverVersion(ctx context.Context, job *config.Job) (string, error) {
+	db, err := sql.Open("postgres", job.Postgres.DSN())
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	var version string
+	if err := db.QueryRowContext(ctx, "SHOW server_version").Scan(&version); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// localPath returns where the backup with the given key is stored on disk
+func localPath(cfg *config.Config, objectKey string) string {
+	return filepath.Join(cfg.Postgres.BackupPath, filepath.FromSlash(objectKey))
+}
+
+// RemovePartialFiles deletes dumps and downloads left behind in the backup
+// directory by a process that was killed before it could clean up
+func RemovePartialFiles(cfg *config.Config) error {
+	root := cfg.Postgres.BackupPath
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() && strings.HasSuffix(d.Name(), workDirSuffix) {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			cfg.Log.Info("🧹 Deleted partial base backup", "dir", path)
+			return fs.SkipDir
+		}
+		if d.IsDir() || !(strings.HasSuffix(d.Name(), PartialSuffix) || strings.HasPrefix(d.Name(), downloadPrefix)) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		cfg.Log.Info("🧹 Deleted partial file", "file", path)
+		return nil
+	})
 }
@@ -0,0 +1,329 @@
+package backups
+
+import (
+	"PostgresDump/internal/config"
+	"PostgresDump/pkg/pgbin"
+	"PostgresDump/pkg/retry"
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// workDirSuffix names the directory pg_basebackup writes its tar files to
+// before they are bundled into the backup file
+const workDirSuffix = ".basebackup" + PartialSuffix
+
+var (
+	startPointPattern = regexp.MustCompile(`write-ahead log start point: ([0-9A-F]+/[0-9A-F]+) on timeline (\d+)`)
+	endPointPattern   = regexp.MustCompile(`write-ahead log end point: ([0-9A-F]+/[0-9A-F]+)`)
+)
+
+// walRange is the part of the WAL a base backup needs to be consistent
+type walRange struct {
+	StartLSN string
+	StopLSN  string
+	Timeline int
+}
+
+// basebackup copies the job's cluster with pg_basebackup in tar format with
+// streamed WAL, and bundles the tar files and the backup manifest it writes
+// into a single tar at filePath, so that the backup is stored like a dump
+func basebackup(ctx context.Context, cfg *config.Config, job *config.Job, filePath string) (walRange, error) {
+	bin, err := ClientBinary(ctx, cfg, job, "pg_basebackup")
+	if err != nil {
+		return walRange{}, fmt.Errorf("failed to select pg_basebackup: %w", err)
+	}
+	cfg.Log.Info("🧰 Using pg_basebackup", "job", job.Name, "version", bin.Version, "path", bin.Path)
+
+	args, err := basebackupArgs(job, bin)
+	if err != nil {
+		return walRange{}, retry.Permanent(err)
+	}
+
+	workDir := filePath + workDirSuffix
+	partialPath := filePath + PartialSuffix
+	defer func() {
+		if err := os.RemoveAll(workDir); err != nil {
+			cfg.Log.Warn("⚠️ Failed to delete base backup directory", "dir", workDir, "error", err)
+		}
+	}()
+	// pg_basebackup refuses a directory left behind by a failed attempt
+	if err := os.RemoveAll(workDir); err != nil {
+		return walRange{}, err
+	}
+
+	cmd := command(ctx, bin.Path, append(args, "-D", workDir)...)
+	// The WAL range is read from the messages when there is no backup_manifest
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+job.Postgres.Password, "LC_ALL=C")
+	output, err := cmd.CombinedOutput()
+	var wal walRange
+	if err == nil {
+		if wal, err = readWALRange(workDir, string(output)); err != nil {
+			cfg.Log.Warn("⚠️ Failed to read the WAL range of the base backup", "job", job.Name, "error", err)
+		}
+		err = bundle(workDir, partialPath)
+	}
+	if err == nil {
+		err = os.Rename(partialPath, filePath)
+	}
+	if err != nil {
+		if err := os.Remove(partialPath); err != nil && !os.IsNotExist(err) {
+			cfg.Log.Warn("⚠️ Failed to delete partial backup", "file", partialPath, "error", err)
+		}
+		if ctx.Err() != nil {
+			return walRange{}, fmt.Errorf("pg_basebackup interrupted: %w", ctx.Err())
+		}
+		return walRange{}, fmt.Errorf("%v\n%s", err, string(output))
+	}
+	return wal, nil
+}
+
+// basebackupArgs returns the pg_basebackup arguments of a job except the target directory
+func basebackupArgs(job *config.Job, bin pgbin.Binary) ([]string, error) {
+	args := []string{
+		"-h", job.Postgres.Host,
+		"-p", job.Postgres.Port,
+		"-U", job.Postgres.User,
+		"-F", "t",
+		"-X", "stream",
+		"--no-password",
+		"--verbose",
+	}
+	if checkpoint := job.Backup.BaseBackup.Checkpoint; checkpoint != "" {
+		args = append(args, "--checkpoint="+checkpoint)
+	}
+
+	compression := job.Backup.BaseBackup.Compression
+	// --compress takes a method since PostgreSQL 15, older releases only gzip with a level
+	if bin.Major >= 15 {
+		return append(args, "--compress="+compression), nil
+	}
+	method, level, _ := strings.Cut(compression, ":")
+	switch {
+	case method == "none":
+		return args, nil
+	case method == "gzip" && level == "":
+		return append(args, "--gzip"), nil
+	case method == "gzip":
+		return append(args, "--compress="+level), nil
+	}
+	return nil, fmt.Errorf("compression %q needs pg_basebackup 15 or newer, found %s", compression, bin.Version)
+}
+
+// complete reports whether the start, end and timeline are all known
+func (w walRange) complete() bool {
+	return w.StartLSN != "" && w.StopLSN != "" && w.Timeline > 0
+}
+
+// readWALRange reads the WAL range from the backup_manifest pg_basebackup
+// writes since PostgreSQL 13, and from its verbose output otherwise
+func readWALRange(dir, output string) (walRange, error) {
+	wal, err := manifestWALRange(filepath.Join(dir, "backup_manifest"))
+	if os.IsNotExist(err) {
+		wal, err = parseWALRange(output), nil
+	}
+	if err == nil && !wal.complete() {
+		err = errors.New("start point, end point or timeline is missing")
+	}
+	return wal, err
+}
+
+// baseBackupManifest is the part of the backup_manifest of pg_basebackup read here
+type baseBackupManifest struct {
+	WALRanges []struct {
+		Timeline int    `json:"Timeline"`
+		StartLSN string `json:"Start-LSN"`
+		EndLSN   string `json:"End-LSN"`
+	} `json:"WAL-Ranges"`
+}
+
+// manifestWALRange reads the WAL range from a backup_manifest. After a
+// timeline switch during the backup it lists a range per timeline, the backup
+// starts in the oldest and ends in the newest.
+func manifestWALRange(path string) (walRange, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return walRange{}, err
+	}
+	var manifest baseBackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return walRange{}, fmt.Errorf("failed to parse %s: %w", filepath.Base(path), err)
+	}
+
+	var wal walRange
+	var start, end uint64
+	for _, r := range manifest.WALRanges {
+		rangeStart, err := parseLSN(r.StartLSN)
+		if err != nil {
+			return walRange{}, err
+		}
+		rangeEnd, err := parseLSN(r.EndLSN)
+		if err != nil {
+			return walRange{}, err
+		}
+		if wal.StartLSN == "" || rangeStart < start {
+			wal.StartLSN, wal.Timeline, start = r.StartLSN, r.Timeline, rangeStart
+		}
+		if wal.StopLSN == "" || rangeEnd > end {
+			wal.StopLSN, end = r.EndLSN, rangeEnd
+		}
+	}
+	return wal, nil
+}
+
+// parseLSN converts an LSN such as 0/2000028 into its position in the WAL
+func parseLSN(lsn string) (uint64, error) {
+	high, low, ok := strings.Cut(lsn, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid LSN %q", lsn)
+	}
+	h, err := strconv.ParseUint(high, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", lsn)
+	}
+	l, err := strconv.ParseUint(low, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", lsn)
+	}
+	return h<<32 | l, nil
+}
+
+// parseWALRange reads the WAL start and end points from the verbose output of
+// pg_basebackup run with LC_ALL=C
+func parseWALRange(output string) walRange {
+	var wal walRange
+	if m := startPointPattern.FindStringSubmatch(output); m != nil {
+		wal.StartLSN = m[1]
+		wal.Timeline, _ = strconv.Atoi(m[2])
+	}
+	if m := endPointPattern.FindStringSubmatch(output); m != nil {
+		wal.StopLSN = m[1]
+	}
+	return wal
+}
+
+// bundle writes the files of dir into an uncompressed tar at path, the tar
+// files of pg_basebackup are compressed already. Every file is deleted once it
+// is bundled, so only the largest one exists twice at a time.
+func bundle(dir, path string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tw := tar.NewWriter(file)
+	for _, entry := range entries {
+		member := filepath.Join(dir, entry.Name())
+		if err := addToBundle(tw, member); err != nil {
+			return fmt.Errorf("failed to bundle %s: %w", entry.Name(), err)
+		}
+		if err := os.Remove(member); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func addToBundle(tw *tar.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// verifyBaseBackup checks that a bundled base backup holds the data directory
+// and WAL archives and reads them through, gzip archives are decompressed
+// and their tar structure checked
+func verifyBaseBackup(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	found := make(map[string]bool)
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("backup archive is not readable: %w", err)
+		}
+		name := header.Name
+		if base, _, ok := strings.Cut(name, ".tar"); ok {
+			found[base] = true
+		}
+		if err := verifyTar(name, tr); err != nil {
+			return fmt.Errorf("%s is not readable: %w", name, err)
+		}
+	}
+	for _, name := range []string{"base", "pg_wal"} {
+		if !found[name] {
+			return fmt.Errorf("backup archive has no %s.tar", name)
+		}
+	}
+	return nil
+}
+
+// verifyTar reads an archive written by pg_basebackup. Plain and gzip tars
+// are checked entry by entry, other files are only read through.
+func verifyTar(name string, r io.Reader) error {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"):
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	case !strings.HasSuffix(name, ".tar"):
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	// Reading to the end also checks the gzip checksum
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
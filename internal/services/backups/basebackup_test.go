@@ -0,0 +1,242 @@
+package backups
+
+import (
+	"PostgresDump/internal/config"
+	"PostgresDump/pkg/pgbin"
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBasebackupArgs(t *testing.T) {
+	tests := []struct {
+		major       int
+		compression string
+		checkpoint  string
+		want        string
+		err         bool
+	}{
+		{major: 16, compression: "zstd:3", want: "--compress=zstd:3"},
+		{major: 15, compression: "server-lz4", want: "--compress=server-lz4"},
+		{major: 15, compression: "none", want: "--compress=none"},
+		{major: 16, compression: "gzip", checkpoint: "fast", want: "--compress=gzip --checkpoint=fast"},
+		{major: 14, compression: "gzip", want: "--gzip"},
+		{major: 14, compression: "gzip:5", want: "--compress=5"},
+		{major: 14, compression: "none", want: ""},
+		{major: 13, compression: "lz4", err: true},
+		{major: 12, compression: "zstd:3", err: true},
+	}
+	for _, tt := range tests {
+		job := &config.Job{
+			Name:     "main",
+			Postgres: &config.Postgres{Host: "db1", Port: "5432", User: "backup"},
+			Backup: &config.BackupConfig{
+				Type:       config.BackupPhysical,
+				BaseBackup: config.BaseBackup{Compression: tt.compression, Checkpoint: tt.checkpoint},
+			},
+		}
+		args, err := basebackupArgs(job, pgbin.Binary{Major: tt.major, Version: "x"})
+		if tt.err {
+			if err == nil {
+				t.Errorf("%d %s: basebackupArgs = %v, want an error", tt.major, tt.compression, args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d %s: basebackupArgs: %v", tt.major, tt.compression, err)
+			continue
+		}
+
+		got := strings.Join(args, " ")
+		if !strings.HasPrefix(got, "-h db1 -p 5432 -U backup -F t -X stream --no-password --verbose") {
+			t.Errorf("%d %s: args = %q", tt.major, tt.compression, got)
+		}
+		for _, arg := range strings.Fields(tt.want) {
+			if !strings.Contains(got, arg) {
+				t.Errorf("%d %s: args = %q, want %s", tt.major, tt.compression, got, arg)
+			}
+		}
+		if tt.want == "" && (strings.Contains(got, "--compress") || strings.Contains(got, "--gzip")) {
+			t.Errorf("%d %s: args = %q, want no compression", tt.major, tt.compression, got)
+		}
+	}
+}
+
+// The verbose output of pg_basebackup 12 run with LC_ALL=C
+const basebackupOutput = `pg_basebackup: initiating base backup, waiting for checkpoint to complete
+pg_basebackup: checkpoint completed
+pg_basebackup: write-ahead log start point: 0/2000028 on timeline 1
+pg_basebackup: starting background WAL receiver
+pg_basebackup: created temporary replication slot "pg_basebackup_1234"
+pg_basebackup: write-ahead log end point: 0/2000100
+pg_basebackup: waiting for background process to finish streaming ...
+pg_basebackup: base backup completed
+`
+
+func TestParseWALRange(t *testing.T) {
+	wal := parseWALRange(basebackupOutput)
+	if wal != (walRange{StartLSN: "0/2000028", StopLSN: "0/2000100", Timeline: 1}) {
+		t.Errorf("parseWALRange = %+v", wal)
+	}
+	if wal := parseWALRange("pg_basebackup: début de la sauvegarde de base"); wal.complete() {
+		t.Errorf("parseWALRange of localized output = %+v, want an incomplete range", wal)
+	}
+}
+
+func TestReadWALRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		output   string
+		want     walRange
+		err      string
+	}{
+		{
+			name:     "manifest",
+			manifest: `{"WAL-Ranges": [{"Timeline": 1, "Start-LSN": "0/9000028", "End-LSN": "0/9000138"}]}`,
+			want:     walRange{StartLSN: "0/9000028", StopLSN: "0/9000138", Timeline: 1},
+		},
+		{
+			name: "timeline switch",
+			manifest: `{"WAL-Ranges": [
+				{"Timeline": 3, "Start-LSN": "1/A0000000", "End-LSN": "1/B0000100"},
+				{"Timeline": 2, "Start-LSN": "0/F0000028", "End-LSN": "1/A0000000"}
+			]}`,
+			want: walRange{StartLSN: "0/F0000028", StopLSN: "1/B0000100", Timeline: 2},
+		},
+		{
+			name:   "output before PostgreSQL 13",
+			output: basebackupOutput,
+			want:   walRange{StartLSN: "0/2000028", StopLSN: "0/2000100", Timeline: 1},
+		},
+		{name: "missing values", output: "pg_basebackup: base backup completed", err: "missing"},
+		{name: "manifest without ranges", manifest: `{"PostgreSQL-Backup-Manifest-Version": 1}`, err: "missing"},
+		{name: "invalid manifest", manifest: `{"WAL-Ranges": [`, err: "failed to parse"},
+		{
+			name:     "invalid LSN",
+			manifest: `{"WAL-Ranges": [{"Timeline": 1, "Start-LSN": "0-1", "End-LSN": "0/2"}]}`,
+			err:      "invalid LSN",
+		},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if tt.manifest != "" {
+			if err := os.WriteFile(filepath.Join(dir, "backup_manifest"), []byte(tt.manifest), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		wal, err := readWALRange(dir, tt.output)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: readWALRange = %+v, %v, want an error containing %q", tt.name, wal, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || wal != tt.want {
+			t.Errorf("%s: readWALRange = %+v, %v, want %+v", tt.name, wal, err, tt.want)
+		}
+	}
+}
+
+// tarFile returns a tar archive holding a single file
+func tarFile(t *testing.T, name, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// bundleFiles writes the files into a pg_basebackup directory and bundles it
+func bundleFiles(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "backup"+workDirSuffix)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(filepath.Dir(dir), "backup_base.tar")
+	if err := bundle(dir, path); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := os.ReadDir(dir); len(left) != 0 {
+		t.Errorf("bundle left %d files behind", len(left))
+	}
+	return path
+}
+
+func TestVerifyBaseBackup(t *testing.T) {
+	base := tarFile(t, "PG_VERSION", "16\n")
+	wal := tarFile(t, "000000010000000000000002", "wal")
+	corrupt := gzipped(t, base)
+	corrupt[len(corrupt)-5] ^= 0xff
+
+	tests := []struct {
+		name  string
+		files map[string][]byte
+		err   string
+	}{
+		{
+			name:  "gzip",
+			files: map[string][]byte{"base.tar.gz": gzipped(t, base), "pg_wal.tar.gz": gzipped(t, wal), "backup_manifest": []byte("{}")},
+		},
+		{
+			name:  "uncompressed with a tablespace",
+			files: map[string][]byte{"base.tar": base, "pg_wal.tar": wal, "16384.tar": base},
+		},
+		{
+			name:  "zstd is only read through",
+			files: map[string][]byte{"base.tar.zst": []byte("zstd data"), "pg_wal.tar": wal},
+		},
+		{name: "no WAL", files: map[string][]byte{"base.tar": base}, err: "no pg_wal.tar"},
+		{name: "no data directory", files: map[string][]byte{"pg_wal.tar": wal}, err: "no base.tar"},
+		{name: "corrupt gzip", files: map[string][]byte{"base.tar.gz": corrupt, "pg_wal.tar": wal}, err: "base.tar.gz is not readable"},
+		{name: "truncated tar", files: map[string][]byte{"base.tar": base[:300], "pg_wal.tar": wal}, err: "base.tar is not readable"},
+	}
+	for _, tt := range tests {
+		err := verifyBaseBackup(bundleFiles(t, tt.files))
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: verifyBaseBackup = %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: verifyBaseBackup = %v, want an error containing %q", tt.name, err, tt.err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "main.dump")
+	if err := os.WriteFile(path, []byte("PGDMP"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyBaseBackup(path); err == nil {
+		t.Error("verifyBaseBackup of a dump succeeded")
+	}
+}
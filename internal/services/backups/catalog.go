@@ -0,0 +1,138 @@
+package backups
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/config"
+	"PostgresDump/pkg/stree"
+	"strconv"
+	"time"
+)
+
+// Formats of the backup file: a pg_dump custom archive, or a tar of the
+// pg_basebackup tar files of a physical job
+const (
+	formatCustom     = "custom"
+	formatBaseBackup = "basebackup"
+)
+
+// S3 user metadata keys, S3 always returns them lowercased
+const (
+	metaJob       = "job"
+	metaCluster   = "cluster"
+	metaHost      = "host"
+	metaDatabase  = "database"
+	metaFormat    = "format"
+	metaCreatedAt = "created-at"
+	metaSHA256    = "sha256"
+	metaServerVer = "server-version"
+	metaStartLSN  = "start-lsn"
+	metaStopLSN   = "stop-lsn"
+	metaTimeline  = "timeline"
+)
+
+func newEntry(job *config.Job, objectKey string, createdAt time.Time) catalog.Entry {
+	return catalog.Entry{
+		Job:       job.Name,
+		Cluster:   job.Backup.Cluster,
+		Host:      job.Postgres.Host,
+		Database:  job.Postgres.Dbname,
+		Key:       objectKey,
+		Format:    jobFormat(job),
+		CreatedAt: createdAt,
+	}
+}
+
+// jobFormat returns the format of the backups a job creates
+func jobFormat(job *config.Job) string {
+	if job.Backup.Type == config.BackupPhysical {
+		return formatBaseBackup
+	}
+	return formatCustom
+}
+
+// IsBaseBackup reports whether a backup is a physical base backup rather than a pg_dump archive
+func IsBaseBackup(e catalog.Entry) bool {
+	return e.Format == formatBaseBackup
+}
+
+func localEntry(e catalog.Entry, path string) catalog.Entry {
+	e.Location = catalog.LocationLocal
+	e.Path = path
+	e.ID = catalog.EntryID(e.Location, e.Key)
+	return e
+}
+
+func s3Entry(e catalog.Entry, bucket string) catalog.Entry {
+	e.Location = catalog.LocationS3
+	e.Bucket = bucket
+	e.ID = catalog.EntryID(e.Location, e.Key)
+	return e
+}
+
+// recordEntry adds a backup to the catalog, a catalog error never fails the backup itself
+func recordEntry(cfg *config.Config, e catalog.Entry) {
+	if err := cfg.Catalog.Put(e); err != nil {
+		cfg.Log.Warn("⚠️ Failed to record backup in catalog", "key", e.Key, "error", err)
+	}
+}
+
+// forgetEntry removes a deleted backup from the catalog
+func forgetEntry(cfg *config.Config, location, key string) {
+	if err := cfg.Catalog.Remove(location, key); err != nil {
+		cfg.Log.Warn("⚠️ Failed to remove backup from catalog", "key", key, "error", err)
+	}
+}
+
+// entryMetadata converts a catalog entry into S3 user metadata
+func entryMetadata(e catalog.Entry) map[string]string {
+	metadata := map[string]string{
+		metaJob:       e.Job,
+		metaCluster:   e.Cluster,
+		metaHost:      e.Host,
+		metaDatabase:  e.Database,
+		metaFormat:    e.Format,
+		metaCreatedAt: e.CreatedAt.Format(time.RFC3339),
+		metaSHA256:    e.SHA256,
+		metaServerVer: e.ServerVersion,
+	}
+	if e.StartLSN != "" {
+		metadata[metaStartLSN] = e.StartLSN
+		metadata[metaStopLSN] = e.StopLSN
+		metadata[metaTimeline] = strconv.Itoa(e.Timeline)
+	}
+	return metadata
+}
+
+// entryFromMetadata fills entry fields from S3 object attributes, metadata wins over values derived from the key
+func entryFromMetadata(e catalog.Entry, info *stree.ObjectInfo) catalog.Entry {
+	e.Size = info.Size
+	if value := info.Metadata[metaJob]; value != "" {
+		e.Job = value
+	}
+	if value := info.Metadata[metaCluster]; value != "" {
+		e.Cluster = value
+	}
+	if value := info.Metadata[metaHost]; value != "" {
+		e.Host = value
+	}
+	if value := info.Metadata[metaDatabase]; value != "" {
+		e.Database = value
+	}
+	if value := info.Metadata[metaFormat]; value != "" {
+		e.Format = value
+	}
+	if value := info.Metadata[metaSHA256]; value != "" {
+		e.SHA256 = value
+	}
+	if value := info.Metadata[metaServerVer]; value != "" {
+		e.ServerVersion = value
+	}
+	if value := info.Metadata[metaStartLSN]; value != "" {
+		e.StartLSN, e.StopLSN = value, info.Metadata[metaStopLSN]
+		e.Timeline, _ = strconv.Atoi(info.Metadata[metaTimeline])
+	}
+	if createdAt, err := time.Parse(time.RFC3339, info.Metadata[metaCreatedAt]); err == nil {
+		e.CreatedAt = createdAt
+	}
+	return e
+}
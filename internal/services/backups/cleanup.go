@@ -1,101 +1,245 @@
 package backups
 
 import (
+	"PostgresDump/internal/catalog"
 	"PostgresDump/internal/config"
 	"PostgresDump/pkg/stree"
+	"context"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
+	"time"
 )
 
-func CleanupOldBackups(cfg *config.Config) error {
-	cfg.Log.Info("🔄 Starting cleanup of old backups...")
+func CleanupOldBackups(ctx context.Context, cfg *config.Config, job *config.Job) error {
+	_, err := PruneBackups(ctx, cfg, job, false)
+	return err
+}
+
+// PruneBackups deletes the backups of a job exceeding keep_copies and returns their keys.
+// With dryRun nothing is deleted, the returned keys are the ones that would be.
+func PruneBackups(ctx context.Context, cfg *config.Config, job *config.Job, dryRun bool) ([]string, error) {
+	cfg.Log.Info("🔄 Starting cleanup of old backups...", "job", job.Name, "dryRun", dryRun)
 
 	if cfg.S3Client != nil {
-		return cleanupOldBackupsFromS3(cfg)
+		return cleanupOldBackupsFromS3(ctx, cfg, job, dryRun)
 	}
 
-	files, err := filepath.Glob(cfg.Postgres.BackupPath + "/*.dump")
+	files, err := listLocalBackups(cfg, job)
 	if err != nil {
 		cfg.Log.Error("❌ Error getting list of local files", "error", err)
-		return err
+		return nil, err
 	}
+	files = withoutPinned(cfg, job, catalog.LocationLocal, files)
 
 	if len(files) == 0 {
 		cfg.Log.Warn("📂 No local backups to clean up")
-		return nil
+		return nil, nil
 	}
 
-	if cfg.Backup.KeepCopies <= 0 {
-		cfg.Log.Warn("⚠️ KeepCopies parameter <= 0, cleanup not performed", "keepCopies", cfg.Backup.KeepCopies)
-		return nil
+	if job.Backup.KeepCopies <= 0 {
+		cfg.Log.Warn("⚠️ KeepCopies parameter <= 0, cleanup not performed", "keepCopies", job.Backup.KeepCopies)
+		return nil, nil
 	}
 
-	sort.Slice(files, func(i, j int) bool {
-		infoI, errI := os.Stat(files[i])
-		infoJ, errJ := os.Stat(files[j])
-		if errI != nil || errJ != nil {
-			cfg.Log.Warn("⚠️ Error getting file date, comparing by name", "fileI", files[i], "fileJ", files[j])
-			return files[i] < files[j]
-		}
-		return infoI.ModTime().Before(infoJ.ModTime())
-	})
-
-	toDelete := len(files) - cfg.Backup.KeepCopies
+	toDelete := len(files) - job.Backup.KeepCopies
 	if toDelete <= 0 {
 		cfg.Log.Info("✅ Number of backups within limit, deletion not required")
-		return nil
+		return nil, nil
 	}
 
+	var deleted []string
 	for i := 0; i < toDelete; i++ {
-		err := os.Remove(files[i])
+		file := localPath(cfg, files[i].key)
+		if dryRun {
+			cfg.Log.Info("📝 Would delete old local backup", "file", file)
+			deleted = append(deleted, files[i].key)
+			continue
+		}
+		err := os.Remove(file)
 		if err != nil {
-			cfg.Log.Warn("⚠️ Error deleting old local backup", "file", files[i], "error", err)
+			cfg.Log.Warn("⚠️ Error deleting old local backup", "file", file, "error", err)
 			continue
 		}
-		cfg.Log.Info("✅ Successfully deleted old local backup", "file", files[i])
+		if err := os.Remove(file + ManifestSuffix); err != nil && !os.IsNotExist(err) {
+			cfg.Log.Warn("⚠️ Error deleting manifest of old local backup", "file", file, "error", err)
+		}
+		forgetEntry(cfg, catalog.LocationLocal, files[i].key)
+		deleted = append(deleted, files[i].key)
+		cfg.Log.Info("✅ Successfully deleted old local backup", "file", file)
 	}
 
 	cfg.Log.Info("🧹 Local backups cleanup completed")
 
-	return nil
+	return deleted, nil
 }
 
-func cleanupOldBackupsFromS3(cfg *config.Config) error {
+func cleanupOldBackupsFromS3(ctx context.Context, cfg *config.Config, job *config.Job, dryRun bool) ([]string, error) {
 
-	files, err := stree.ListFilesInS3Directory(cfg.S3Client, cfg.BucketName, cfg.Postgres.BackupPath)
+	files, err := listS3Backups(ctx, cfg, job)
 	if err != nil {
-		return fmt.Errorf("failed to get list of backups from S3: %w", err)
+		return nil, fmt.Errorf("failed to get list of backups from S3: %w", err)
 	}
+	files = withoutPinned(cfg, job, catalog.LocationS3, files)
 
 	if len(files) == 0 {
 		cfg.Log.Warn("📂 No backups in S3 to delete")
-		return nil
+		return nil, nil
 	}
 
-	if len(files) <= cfg.Backup.KeepCopies {
+	if len(files) <= job.Backup.KeepCopies {
 		cfg.Log.Info("✅ Number of backups within limit, deletion not required",
-			"keepCopies", cfg.Backup.KeepCopies, "totalFiles", len(files))
-		return nil
+			"keepCopies", job.Backup.KeepCopies, "totalFiles", len(files))
+		return nil, nil
 	}
 
-	sort.Slice(files, func(i, j int) bool {
-		return files[i] < files[j]
-	})
-
-	toDelete := len(files) - cfg.Backup.KeepCopies
+	toDelete := len(files) - job.Backup.KeepCopies
 
+	var deleted []string
 	for i := 0; i < toDelete; i++ {
-		fileToDelete := files[i]
+		fileToDelete := files[i].key
+		pinned, err := pinnedInS3(ctx, cfg, fileToDelete)
+		if err != nil {
+			cfg.Log.Warn("⚠️ Error reading backup tags, keeping the backup", "file", fileToDelete, "error", err)
+			continue
+		}
+		if pinned {
+			cfg.Log.Info("📌 Keeping backup pinned by its tags", "file", fileToDelete)
+			continue
+		}
+		if dryRun {
+			cfg.Log.Info("📝 Would delete backup from S3", "file", fileToDelete)
+			deleted = append(deleted, fileToDelete)
+			continue
+		}
 
-		err := stree.DeleteFileFromS3(cfg.S3Client, cfg.BucketName, fileToDelete)
+		err = stree.DeleteFileFromS3(ctx, cfg.S3Client, cfg.BucketName, fileToDelete)
 		if err != nil {
 			cfg.Log.Warn("⚠️ Error deleting backup from S3", "file", fileToDelete, "error", err)
 			continue
 		}
+		if err := stree.DeleteFileFromS3(ctx, cfg.S3Client, cfg.BucketName, manifestKey(fileToDelete)); err != nil {
+			cfg.Log.Warn("⚠️ Error deleting backup manifest from S3", "file", fileToDelete, "error", err)
+		}
+		forgetEntry(cfg, catalog.LocationS3, fileToDelete)
+		deleted = append(deleted, fileToDelete)
+	}
 
+	return deleted, nil
+}
+
+// withoutPinned drops the backups with an active pin in the catalog, they are
+// neither deleted nor counted against keep_copies. The tags of an S3 backup are
+// only read once it is about to be deleted.
+func withoutPinned(cfg *config.Config, job *config.Job, location string, files []backupFile) []backupFile {
+	var kept []backupFile
+	for _, f := range files {
+		if cfg.Catalog.IsPinned(location, f.key) {
+			continue
+		}
+		kept = append(kept, f)
 	}
+	if pinned := len(files) - len(kept); pinned > 0 {
+		cfg.Log.Info("📌 Keeping pinned backups", "job", job.Name, "pinned", pinned)
+	}
+	return kept
+}
 
+// DeleteBackup deletes a backup with its manifest from storage and the catalog
+func DeleteBackup(ctx context.Context, cfg *config.Config, entry catalog.Entry) error {
+	if entry.Location == catalog.LocationS3 {
+		if cfg.S3Client == nil {
+			return fmt.Errorf("backup %s is stored in S3, but S3 is not configured", entry.ID)
+		}
+		if err := stree.DeleteFileFromS3(ctx, cfg.S3Client, entry.Bucket, entry.Key); err != nil {
+			return err
+		}
+		if err := stree.DeleteFileFromS3(ctx, cfg.S3Client, entry.Bucket, manifestKey(entry.Key)); err != nil {
+			cfg.Log.Warn("⚠️ Error deleting backup manifest from S3", "file", entry.Key, "error", err)
+		}
+	} else {
+		if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(entry.Path + ManifestSuffix); err != nil && !os.IsNotExist(err) {
+			cfg.Log.Warn("⚠️ Error deleting manifest of local backup", "file", entry.Path, "error", err)
+		}
+	}
+	forgetEntry(cfg, entry.Location, entry.Key)
 	return nil
 }
+
+// backupFile is a stored backup whose key matches the configured key template
+type backupFile struct {
+	key       string
+	createdAt time.Time
+}
+
+// listLocalBackups returns local backups of the job, oldest first
+func listLocalBackups(cfg *config.Config, job *config.Job) ([]backupFile, error) {
+	vars := job.KeyVars(time.Time{})
+	root := cfg.Postgres.BackupPath
+	var files []backupFile
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if createdAt, ok := job.Backup.Key.Match(key, vars); ok && ownFormat(cfg, job, catalog.LocationLocal, key) {
+			files = append(files, backupFile{key: key, createdAt: createdAt})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortBackups(files)
+	return files, nil
+}
+
+// listS3Backups returns backups of the job stored in S3, oldest first
+func listS3Backups(ctx context.Context, cfg *config.Config, job *config.Job) ([]backupFile, error) {
+	vars := job.KeyVars(time.Time{})
+	keys, err := stree.ListFilesInS3(ctx, cfg.S3Client, cfg.BucketName, job.Backup.Key.Prefix(vars))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []backupFile
+	for _, key := range keys {
+		if createdAt, ok := job.Backup.Key.Match(key, vars); ok && ownFormat(cfg, job, catalog.LocationS3, key) {
+			files = append(files, backupFile{key: key, createdAt: createdAt})
+		}
+	}
+
+	sortBackups(files)
+	return files, nil
+}
+
+// ownFormat reports whether a listed backup has the format of the job's backups.
+// A key the catalog records with another format, such as a base backup under
+// the key template of a logical job, is never pruned by the job.
+func ownFormat(cfg *config.Config, job *config.Job, location, key string) bool {
+	e, err := cfg.Catalog.Get(catalog.EntryID(location, key))
+	return err != nil || e.Format == "" || e.Format == jobFormat(job)
+}
+
+func sortBackups(files []backupFile) {
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].createdAt.Equal(files[j].createdAt) {
+			return files[i].key < files[j].key
+		}
+		return files[i].createdAt.Before(files[j].createdAt)
+	})
+}
@@ -0,0 +1,170 @@
+package backups
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/config"
+	"PostgresDump/pkg/keytpl"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const testBucket = "backups"
+
+// fakeS3 serves listing, tagging and deleting of a path-style bucket
+type fakeS3 struct {
+	mu      sync.Mutex
+	keys    []string
+	tags    map[string]map[string]string
+	deleted []string
+	// taggingStatus and taggingCode answer every GetObjectTagging when set
+	taggingStatus int
+	taggingCode   string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+testBucket), "/")
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		prefix := r.URL.Query().Get("prefix")
+		fmt.Fprint(w, `<ListBucketResult><IsTruncated>false</IsTruncated>`)
+		for _, k := range f.keys {
+			if strings.HasPrefix(k, prefix) {
+				fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>1</Size></Contents>`, k)
+			}
+		}
+		fmt.Fprint(w, `</ListBucketResult>`)
+	case r.Method == http.MethodGet && r.URL.Query().Has("tagging"):
+		if f.taggingStatus != 0 {
+			w.WriteHeader(f.taggingStatus)
+			fmt.Fprintf(w, `<Error><Code>%s</Code><Message>tagging</Message></Error>`, f.taggingCode)
+			return
+		}
+		fmt.Fprint(w, `<Tagging><TagSet>`)
+		for k, v := range f.tags[key] {
+			fmt.Fprintf(w, `<Tag><Key>%s</Key><Value>%s</Value></Tag>`, k, v)
+		}
+		fmt.Fprint(w, `</TagSet></Tagging>`)
+	case r.Method == http.MethodDelete:
+		f.deleted = append(f.deleted, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) deletedKeys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.deleted)
+}
+
+// pruneTest returns a config using the fake bucket and a job keeping one copy,
+// the bucket holds three backups of the job, oldest first
+func pruneTest(t *testing.T, fake *fakeS3) (*config.Config, *config.Job, []string) {
+	t.Helper()
+	noTagging.Store(false)
+	t.Cleanup(func() { noTagging.Store(false) })
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := s3.New(s3.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(server.URL),
+		UsePathStyle:     true,
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		RetryMaxAttempts: 1,
+	})
+
+	c, _, err := catalog.Open(filepath.Join(t.TempDir(), "catalog.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Log:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Catalog:    c,
+		S3Client:   client,
+		BucketName: testBucket,
+	}
+	key, err := keytpl.Parse(keytpl.DefaultTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &config.Job{
+		Name:     "app",
+		Postgres: &config.Postgres{Host: "db1", Port: "5432", Dbname: "app"},
+		Backup:   &config.BackupConfig{Cluster: "main", Key: key, KeepCopies: 1},
+	}
+
+	var keys []string
+	for day := 1; day <= 3; day++ {
+		keys = append(keys, key.Render(job.KeyVars(time.Date(2024, 3, day, 2, 0, 0, 0, time.Local))))
+	}
+	fake.keys = keys
+	return cfg, job, keys
+}
+
+func TestPruneKeepsBackupPinnedByTags(t *testing.T) {
+	fake := &fakeS3{tags: make(map[string]map[string]string)}
+	cfg, job, keys := pruneTest(t, fake)
+	// The pin was set through another instance, the local catalog doesn't know it
+	fake.tags[keys[0]] = map[string]string{tagPinned: "true", tagPinReason: "audit"}
+
+	deleted, err := PruneBackups(context.Background(), cfg, job, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(deleted, []string{keys[1]}) {
+		t.Errorf("PruneBackups deleted %v, want only %s", deleted, keys[1])
+	}
+	for _, key := range fake.deletedKeys() {
+		if key == keys[0] {
+			t.Errorf("the backup pinned by its tags was deleted")
+		}
+	}
+}
+
+func TestPruneKeepsBackupWithUnreadableTags(t *testing.T) {
+	fake := &fakeS3{taggingStatus: http.StatusBadRequest, taggingCode: "InvalidRequest"}
+	cfg, job, _ := pruneTest(t, fake)
+
+	deleted, err := PruneBackups(context.Background(), cfg, job, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 || len(fake.deletedKeys()) != 0 {
+		t.Errorf("PruneBackups deleted %v although the tags couldn't be read", deleted)
+	}
+}
+
+func TestPruneWithoutTagging(t *testing.T) {
+	fake := &fakeS3{taggingStatus: http.StatusNotImplemented, taggingCode: "NotImplemented"}
+	cfg, job, keys := pruneTest(t, fake)
+
+	deleted, err := PruneBackups(context.Background(), cfg, job, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(deleted, keys[:2]) {
+		t.Errorf("PruneBackups deleted %v, want %v", deleted, keys[:2])
+	}
+	if !noTagging.Load() {
+		t.Error("a store without tagging wasn't remembered")
+	}
+}
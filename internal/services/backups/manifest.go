@@ -0,0 +1,103 @@
+package backups
+
+import (
+	"PostgresDump/internal/catalog"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ManifestSuffix is appended to a backup key to get the key of its manifest
+const ManifestSuffix = ".manifest.json"
+
+const manifestVersion = 1
+
+// Manifest is stored next to every backup, locally and in S3, and describes it
+// well enough to rebuild the catalog without any other state
+type Manifest struct {
+	Version       int       `json:"version"`
+	Job           string    `json:"job"`
+	Cluster       string    `json:"cluster"`
+	Host          string    `json:"host"`
+	Database      string    `json:"database"`
+	Key           string    `json:"key"`
+	Format        string    `json:"format"`
+	Size          int64     `json:"size"`
+	CreatedAt     time.Time `json:"created_at"`
+	SHA256        string    `json:"sha256,omitempty"`
+	ServerVersion string    `json:"server_version,omitempty"`
+	// StartLSN, StopLSN and Timeline are only set for base backups
+	StartLSN string `json:"start_lsn,omitempty"`
+	StopLSN  string `json:"stop_lsn,omitempty"`
+	Timeline int    `json:"timeline,omitempty"`
+}
+
+func manifestKey(objectKey string) string {
+	return objectKey + ManifestSuffix
+}
+
+func isManifestKey(key string) bool {
+	return strings.HasSuffix(key, ManifestSuffix)
+}
+
+func newManifest(e catalog.Entry) Manifest {
+	return Manifest{
+		Version:       manifestVersion,
+		Job:           e.Job,
+		Cluster:       e.Cluster,
+		Host:          e.Host,
+		Database:      e.Database,
+		Key:           e.Key,
+		Format:        e.Format,
+		Size:          e.Size,
+		CreatedAt:     e.CreatedAt,
+		SHA256:        e.SHA256,
+		ServerVersion: e.ServerVersion,
+		StartLSN:      e.StartLSN,
+		StopLSN:       e.StopLSN,
+		Timeline:      e.Timeline,
+	}
+}
+
+func writeManifest(path string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest %s: %w", path, err)
+	}
+	return nil
+}
+
+func parseManifest(data []byte) (Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if m.Key == "" || m.CreatedAt.IsZero() {
+		return Manifest{}, fmt.Errorf("manifest is missing key or creation time")
+	}
+	return m, nil
+}
+
+// entry converts the manifest into a catalog entry without a location
+func (m Manifest) entry() catalog.Entry {
+	return catalog.Entry{
+		Job:           m.Job,
+		Cluster:       m.Cluster,
+		Host:          m.Host,
+		Database:      m.Database,
+		Key:           m.Key,
+		Format:        m.Format,
+		Size:          m.Size,
+		CreatedAt:     m.CreatedAt,
+		SHA256:        m.SHA256,
+		ServerVersion: m.ServerVersion,
+		StartLSN:      m.StartLSN,
+		StopLSN:       m.StopLSN,
+		Timeline:      m.Timeline,
+	}
+}
@@ -0,0 +1,90 @@
+package backups
+
+import (
+	"PostgresDump/internal/config"
+	"PostgresDump/pkg/pgbin"
+	"PostgresDump/pkg/retry"
+	"context"
+	"database/sql"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// interruptWait is how long a client program may take to exit after it was
+// interrupted before it is killed
+const interruptWait = 10 * time.Second
+
+var (
+	binariesMu sync.Mutex
+	// binaries caches the installed client programs by name, they don't change while running
+	binaries = make(map[string][]pgbin.Binary)
+)
+
+// installedBinaries returns the installed versions of a client program, newest first
+func installedBinaries(cfg *config.Config, name string) ([]pgbin.Binary, error) {
+	binariesMu.Lock()
+	defer binariesMu.Unlock()
+	if found, ok := binaries[name]; ok {
+		return found, nil
+	}
+	found, err := pgbin.Find(name, cfg.PGBinDirs)
+	if err != nil {
+		return nil, err
+	}
+	binaries[name] = found
+	return found, nil
+}
+
+// ClientBinary picks the installed client program, such as pg_dump, that is
+// compatible with the job's PostgreSQL server. A missing program is a
+// permanent error, retrying doesn't install it.
+func ClientBinary(ctx context.Context, cfg *config.Config, job *config.Job, name string) (pgbin.Binary, error) {
+	found, err := installedBinaries(cfg, name)
+	if err != nil {
+		return pgbin.Binary{}, retry.Permanent(err)
+	}
+	major, err := ServerMajor(ctx, job)
+	if err != nil {
+		return pgbin.Binary{}, err
+	}
+	b, err := pgbin.Select(found, major)
+	if err != nil {
+		return pgbin.Binary{}, retry.Permanent(err)
+	}
+	return b, nil
+}
+
+// newestBinary returns the newest installed version of a client program
+func newestBinary(cfg *config.Config, name string) (pgbin.Binary, error) {
+	found, err := installedBinaries(cfg, name)
+	if err != nil {
+		return pgbin.Binary{}, err
+	}
+	return found[0], nil
+}
+
+// ServerMajor asks the job's PostgreSQL server for its major version
+func ServerMajor(ctx context.Context, job *config.Job) (int, error) {
+	db, err := sql.Open("postgres", job.Postgres.DSN())
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var versionNum int
+	if err := db.QueryRowContext(ctx, "SHOW server_version_num").Scan(&versionNum); err != nil {
+		return 0, err
+	}
+	return pgbin.ServerMajor(versionNum), nil
+}
+
+// command prepares a client program that is interrupted, rather than killed,
+// when ctx is canceled so that it can close its connection
+func command(ctx context.Context, path string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = interruptWait
+	return cmd
+}
@@ -0,0 +1,151 @@
+package backups
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/config"
+	"PostgresDump/pkg/stree"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// S3 object tags of a pinned backup, they keep the pin when the catalog is
+// rebuilt, also by other instances sharing the bucket
+const (
+	tagPinned     = "pgsnapsafe-pinned"
+	tagPinReason  = "pgsnapsafe-pin-reason"
+	tagPinnedTill = "pgsnapsafe-pin-until"
+)
+
+// maxTagValue is the longest value S3 accepts for an object tag
+const maxTagValue = 256
+
+// PinBackup protects a backup from retention until until, or forever when it
+// is zero. Backups in S3 are tagged as well.
+func PinBackup(ctx context.Context, cfg *config.Config, entry catalog.Entry, reason string, until time.Time) (catalog.Entry, error) {
+	if entry.Location == catalog.LocationS3 {
+		err := updateTags(ctx, cfg, entry, func(tags map[string]string) {
+			tags[tagPinned] = "true"
+			delete(tags, tagPinReason)
+			delete(tags, tagPinnedTill)
+			if value := tagValue(reason); value != "" {
+				tags[tagPinReason] = value
+			}
+			if !until.IsZero() {
+				tags[tagPinnedTill] = until.UTC().Format(time.RFC3339)
+			}
+		})
+		if err != nil {
+			return catalog.Entry{}, err
+		}
+	}
+	return cfg.Catalog.Pin(entry.ID, reason, until)
+}
+
+// UnpinBackup removes the pin of a backup, retention treats it like any other afterwards
+func UnpinBackup(ctx context.Context, cfg *config.Config, entry catalog.Entry) (catalog.Entry, error) {
+	if entry.Location == catalog.LocationS3 {
+		err := updateTags(ctx, cfg, entry, func(tags map[string]string) {
+			delete(tags, tagPinned)
+			delete(tags, tagPinReason)
+			delete(tags, tagPinnedTill)
+		})
+		if err != nil {
+			return catalog.Entry{}, err
+		}
+	}
+	return cfg.Catalog.Unpin(entry.ID)
+}
+
+// updateTags changes the pin tags of an S3 backup and keeps all other tags
+func updateTags(ctx context.Context, cfg *config.Config, entry catalog.Entry, fn func(tags map[string]string)) error {
+	if cfg.S3Client == nil {
+		return fmt.Errorf("backup %s is stored in S3, but S3 is not configured", entry.ID)
+	}
+	tags, err := stree.GetObjectTags(ctx, cfg.S3Client, entry.Bucket, entry.Key)
+	if err != nil {
+		return err
+	}
+	fn(tags)
+	return stree.PutObjectTags(ctx, cfg.S3Client, entry.Bucket, entry.Key, tags)
+}
+
+// pinFromTags fills the pin fields of an entry from the tags of its S3 object
+func pinFromTags(e catalog.Entry, tags map[string]string) catalog.Entry {
+	if tags[tagPinned] != "true" {
+		return e
+	}
+	e.Pinned, e.PinReason = true, tags[tagPinReason]
+	if until, err := time.Parse(time.RFC3339, tags[tagPinnedTill]); err == nil {
+		e.PinnedUntil = &until
+	}
+	return e
+}
+
+// noTagging is set once the store turned out not to return object tags, pins
+// are then only known from the catalog
+var noTagging atomic.Bool
+
+// pinTags reads the tags of an S3 backup. On a store without object tagging,
+// or with credentials that may not read tags, it warns once and returns no tags.
+func pinTags(ctx context.Context, cfg *config.Config, key string) (map[string]string, error) {
+	if noTagging.Load() {
+		return nil, nil
+	}
+	tags, err := stree.GetObjectTags(ctx, cfg.S3Client, cfg.BucketName, key)
+	if taggingUnsupported(err) {
+		if !noTagging.Swap(true) {
+			cfg.Log.Warn("⚠️ Backup tags can't be read, pins are only known from the catalog", "error", err)
+		}
+		return nil, nil
+	}
+	return tags, err
+}
+
+// pinnedInS3 reports whether the tags of an S3 backup hold an active pin, a
+// pin set through another instance is only in its own catalog
+func pinnedInS3(ctx context.Context, cfg *config.Config, key string) (bool, error) {
+	tags, err := pinTags(ctx, cfg, key)
+	if err != nil {
+		return false, err
+	}
+	return pinFromTags(catalog.Entry{}, tags).PinActive(time.Now()), nil
+}
+
+// taggingUnsupported reports whether err means the store doesn't implement
+// object tagging or the credentials may not read tags, so no tag can be read
+func taggingUnsupported(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "NotImplemented", "AccessDenied":
+		return true
+	}
+	return false
+}
+
+// tagValue drops the characters S3 does not allow in tag values and shortens
+// the value to the allowed length
+func tagValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune(" +-=._:/@", r):
+			return r
+		case r == '\t' || r == '\n':
+			return ' '
+		}
+		return -1
+	}, value)
+	value = strings.TrimSpace(value)
+	if len(value) > maxTagValue {
+		value = strings.TrimSpace(value[:maxTagValue])
+	}
+	return value
+}
@@ -0,0 +1,66 @@
+package backups
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/config"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestWithoutPinned(t *testing.T) {
+	c, _, err := catalog.Open(filepath.Join(t.TempDir(), "catalog.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Log: slog.New(slog.NewTextHandler(io.Discard, nil)), Catalog: c}
+	job := &config.Job{Name: "app"}
+
+	var files []backupFile
+	for i, key := range []string{"app/1.dump", "app/2.dump", "app/3.dump"} {
+		created := time.Date(2024, 3, i+1, 0, 0, 0, 0, time.UTC)
+		files = append(files, backupFile{key: key, createdAt: created})
+		entry := catalog.Entry{Job: "app", Location: catalog.LocationS3, Key: key, CreatedAt: created}
+		if err := c.Put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Pin(catalog.EntryID(catalog.LocationS3, "app/1.dump"), "audit", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Pin(catalog.EntryID(catalog.LocationS3, "app/2.dump"), "expired", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	kept := withoutPinned(cfg, job, catalog.LocationS3, files)
+	if len(kept) != 2 || kept[0].key != "app/2.dump" || kept[1].key != "app/3.dump" {
+		t.Errorf("withoutPinned = %+v, want the unpinned and the expired backup", kept)
+	}
+	if kept := withoutPinned(cfg, job, catalog.LocationLocal, files); len(kept) != 3 {
+		t.Errorf("withoutPinned of local backups = %+v, the pins are on S3 backups", kept)
+	}
+}
+
+func TestTaggingUnsupported(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: fmt.Errorf("get tags: %w", &smithy.GenericAPIError{Code: "NotImplemented"}), want: true},
+		{err: &smithy.GenericAPIError{Code: "AccessDenied"}, want: true},
+		{err: &smithy.GenericAPIError{Code: "NoSuchKey"}},
+		{err: errors.New("connection reset")},
+		{err: nil},
+	}
+	for _, tt := range tests {
+		if got := taggingUnsupported(tt.err); got != tt.want {
+			t.Errorf("taggingUnsupported(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package backups
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/config"
+	"PostgresDump/pkg/stree"
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// File names produced by older pgsnapsafe versions: CreateBackup wrote
+// postgres_backup_<timestamp>.dump locally and UploadFileToS3 renamed it to
+// <yyyymmddhhmmss>_<uuid>.dump under the backup directory
+var (
+	legacyLocalName = regexp.MustCompile(`^postgres_backup_(\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2})\.dump$`)
+	legacyS3Name    = regexp.MustCompile(`^(\d{14})_[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\.dump$`)
+)
+
+// RebuildResult tells how many backups were found and how each of them was identified
+type RebuildResult struct {
+	Entries      int `json:"entries"`
+	FromManifest int `json:"from_manifest"`
+	FromMetadata int `json:"from_metadata"`
+	FromName     int `json:"from_name"`
+	Skipped      int `json:"skipped"`
+}
+
+// EnsureCatalog rebuilds the catalog from storage when the catalog file didn't exist yet
+func EnsureCatalog(ctx context.Context, cfg *config.Config) error {
+	if !cfg.CatalogCreated {
+		return nil
+	}
+
+	cfg.Log.Info("📚 Backup catalog not found, rebuilding from storage...", "path", cfg.Catalog.Path())
+	result, err := RebuildCatalog(ctx, cfg, nil)
+	if err != nil {
+		return err
+	}
+	cfg.CatalogCreated = false
+	cfg.Log.Info("✅ Backup catalog rebuilt", "backups", result.Entries, "skipped", result.Skipped)
+	return nil
+}
+
+// RebuildCatalog scans the local backup directory and S3 and replaces the catalog
+// with every backup found. Besides the key prefix of every job and the
+// directory used by older versions, extra S3 prefixes may be scanned.
+func RebuildCatalog(ctx context.Context, cfg *config.Config, prefixes []string) (RebuildResult, error) {
+	var result RebuildResult
+	var entries []catalog.Entry
+
+	local, err := scanLocal(cfg, &result)
+	if err != nil {
+		return result, err
+	}
+	entries = append(entries, local...)
+
+	if cfg.S3Client != nil {
+		remote, err := scanS3(ctx, cfg, prefixes, &result)
+		if err != nil {
+			return result, err
+		}
+		entries = append(entries, remote...)
+	}
+
+	if err := cfg.Catalog.Replace(entries); err != nil {
+		return result, err
+	}
+	result.Entries = len(entries)
+	return result, nil
+}
+
+func scanLocal(cfg *config.Config, result *RebuildResult) ([]catalog.Entry, error) {
+	root := cfg.Postgres.BackupPath
+	sizes := make(map[string]int64)
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		sizes[filepath.ToSlash(rel)] = info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []catalog.Entry
+	for key, size := range sizes {
+		if isManifestKey(key) {
+			continue
+		}
+
+		var entry catalog.Entry
+		var ok bool
+		if _, hasManifest := sizes[manifestKey(key)]; hasManifest {
+			entry, ok = localManifestEntry(cfg, key)
+			if ok {
+				result.FromManifest++
+			}
+		}
+		if !ok {
+			entry, ok = entryFromName(cfg, key)
+			if ok {
+				result.FromName++
+			}
+		}
+		if !ok {
+			if isDumpFile(key) {
+				cfg.Log.Warn("⚠️ Unrecognized backup file, skipping", "file", key)
+				result.Skipped++
+			}
+			continue
+		}
+
+		entry.Size = size
+		entries = append(entries, localEntry(entry, localPath(cfg, key)))
+	}
+	return entries, nil
+}
+
+func localManifestEntry(cfg *config.Config, key string) (catalog.Entry, bool) {
+	data, err := os.ReadFile(localPath(cfg, manifestKey(key)))
+	if err != nil {
+		cfg.Log.Warn("⚠️ Error reading backup manifest", "file", key, "error", err)
+		return catalog.Entry{}, false
+	}
+	m, err := parseManifest(data)
+	if err != nil {
+		cfg.Log.Warn("⚠️ Invalid backup manifest", "file", key, "error", err)
+		return catalog.Entry{}, false
+	}
+	entry := m.entry()
+	entry.Key = key
+	return entry, true
+}
+
+func scanS3(ctx context.Context, cfg *config.Config, prefixes []string, result *RebuildResult) ([]catalog.Entry, error) {
+	defaults := []string{cfg.Postgres.BackupPath + "/"}
+	for _, job := range cfg.Jobs {
+		defaults = append(defaults, job.Backup.Key.Prefix(job.KeyVars(time.Time{})))
+	}
+	prefixes = append(defaults, prefixes...)
+
+	keys := make(map[string]bool)
+	for _, prefix := range coveringPrefixes(prefixes) {
+		found, err := stree.ListFilesInS3(ctx, cfg.S3Client, cfg.BucketName, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range found {
+			keys[key] = true
+		}
+	}
+
+	var entries []catalog.Entry
+	for key := range keys {
+		if isManifestKey(key) {
+			continue
+		}
+
+		hasManifest := keys[manifestKey(key)]
+		named, isNamed := entryFromName(cfg, key)
+		// Other objects under a prefix are not read, on a shared bucket that
+		// would cost requests for every object
+		if !hasManifest && !isNamed && !isDumpFile(key) {
+			continue
+		}
+
+		var entry catalog.Entry
+		var ok bool
+		if hasManifest {
+			entry, ok = s3ManifestEntry(ctx, cfg, key)
+			if ok {
+				result.FromManifest++
+			}
+		}
+
+		info, err := stree.HeadFileInS3(ctx, cfg.S3Client, cfg.BucketName, key)
+		if err != nil {
+			cfg.Log.Warn("⚠️ Error reading backup metadata", "key", key, "error", err)
+		}
+		if !ok && info != nil && info.Metadata[metaCreatedAt] != "" {
+			entry, ok = entryFromMetadata(catalog.Entry{Key: key, Format: formatCustom}, info), true
+			result.FromMetadata++
+		}
+		if !ok {
+			entry, ok = named, isNamed
+			if ok {
+				result.FromName++
+			}
+		}
+		if !ok {
+			if isDumpFile(key) {
+				cfg.Log.Warn("⚠️ Unrecognized backup object, skipping", "key", key)
+				result.Skipped++
+			}
+			continue
+		}
+
+		if info != nil {
+			entry.Size = info.Size
+		}
+		tags, err := pinTags(ctx, cfg, key)
+		if err != nil {
+			cfg.Log.Warn("⚠️ Error reading backup tags", "key", key, "error", err)
+		}
+		entries = append(entries, s3Entry(pinFromTags(entry, tags), cfg.BucketName))
+	}
+	return entries, nil
+}
+
+// coveringPrefixes drops the prefixes another one of the list already covers,
+// so that no object is listed twice
+func coveringPrefixes(prefixes []string) []string {
+	sorted := append([]string(nil), prefixes...)
+	sort.Strings(sorted)
+
+	var result []string
+	for _, prefix := range sorted {
+		if len(result) > 0 && strings.HasPrefix(prefix, result[len(result)-1]) {
+			continue
+		}
+		result = append(result, prefix)
+	}
+	return result
+}
+
+func s3ManifestEntry(ctx context.Context, cfg *config.Config, key string) (catalog.Entry, bool) {
+	data, err := stree.ReadFileFromS3(ctx, cfg.S3Client, cfg.BucketName, manifestKey(key))
+	if err != nil {
+		cfg.Log.Warn("⚠️ Error reading backup manifest", "key", key, "error", err)
+		return catalog.Entry{}, false
+	}
+	m, err := parseManifest(data)
+	if err != nil {
+		cfg.Log.Warn("⚠️ Invalid backup manifest", "key", key, "error", err)
+		return catalog.Entry{}, false
+	}
+	entry := m.entry()
+	entry.Key = key
+	return entry, true
+}
+
+// entryFromName identifies a backup by its key alone, using the key templates
+// of the configured jobs first and the naming of older versions after that
+func entryFromName(cfg *config.Config, key string) (catalog.Entry, bool) {
+	var parsed *catalog.Entry
+	for _, job := range cfg.Jobs {
+		vars, ok := job.Backup.Key.Parse(key)
+		if !ok {
+			continue
+		}
+		entry := catalog.Entry{
+			Job:       vars.Job,
+			Cluster:   vars.Cluster,
+			Host:      vars.Host,
+			Database:  vars.Database,
+			Key:       key,
+			Format:    jobFormat(job),
+			CreatedAt: vars.Time,
+		}
+		// Values the template doesn't encode are only known when the key belongs to this job
+		if _, ok := job.Backup.Key.Match(key, job.KeyVars(vars.Time)); ok {
+			return fillFromJob(job, entry), true
+		}
+		if parsed == nil {
+			parsed = &entry
+		}
+	}
+	if parsed != nil {
+		if parsed.Job == "" {
+			parsed.Job = parsed.Database
+		}
+		return *parsed, true
+	}
+
+	createdAt, ok := parseLegacyName(path.Base(key))
+	if !ok || len(cfg.Jobs) == 0 {
+		return catalog.Entry{}, false
+	}
+	// Older versions could only back up the single database from the POSTGRESQL_* variables
+	return newEntry(cfg.Jobs[0], key, createdAt), true
+}
+
+func fillFromJob(job *config.Job, e catalog.Entry) catalog.Entry {
+	defaults := newEntry(job, e.Key, e.CreatedAt)
+	if e.Job == "" {
+		e.Job = defaults.Job
+	}
+	if e.Cluster == "" {
+		e.Cluster = defaults.Cluster
+	}
+	if e.Host == "" {
+		e.Host = defaults.Host
+	}
+	if e.Database == "" {
+		e.Database = defaults.Database
+	}
+	return e
+}
+
+func parseLegacyName(name string) (time.Time, bool) {
+	if m := legacyLocalName.FindStringSubmatch(name); m != nil {
+		t, err := time.ParseInLocation("2006-01-02_15-04-05", m[1], time.Local)
+		return t, err == nil
+	}
+	if m := legacyS3Name.FindStringSubmatch(name); m != nil {
+		t, err := time.ParseInLocation("20060102150405", m[1], time.Local)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+func isDumpFile(key string) bool {
+	return strings.HasSuffix(key, ".dump") || strings.HasSuffix(key, ".tar")
+}
@@ -0,0 +1,183 @@
+package backups
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/config"
+	"PostgresDump/pkg/stree"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// downloadPrefix names the temporary files backups are downloaded to
+const downloadPrefix = ".download-"
+
+// RestoreOptions controls how a backup is restored
+type RestoreOptions struct {
+	// TargetDB is the database restored into, the job database when empty
+	TargetDB string
+	// Clean drops database objects before recreating them
+	Clean bool
+}
+
+// LatestBackup returns the newest cataloged backup of the job
+func LatestBackup(cfg *config.Config, job *config.Job) (catalog.Entry, error) {
+	entries := cfg.Catalog.List(catalog.Filter{Job: job.Name})
+	if len(entries) == 0 {
+		return catalog.Entry{}, catalog.ErrNotFound
+	}
+	return entries[0], nil
+}
+
+// FetchBackup makes a backup available as a local file. Backups stored in S3 are
+// downloaded to a temporary file that is removed by the returned cleanup function.
+func FetchBackup(ctx context.Context, cfg *config.Config, entry catalog.Entry) (string, func(), error) {
+	if entry.Location == catalog.LocationLocal {
+		if _, err := os.Stat(entry.Path); err != nil {
+			return "", nil, fmt.Errorf("local backup is not available: %w", err)
+		}
+		return entry.Path, func() {}, nil
+	}
+
+	if cfg.S3Client == nil {
+		return "", nil, fmt.Errorf("backup %s is stored in S3, but S3 is not configured", entry.ID)
+	}
+
+	if err := os.MkdirAll(cfg.Postgres.BackupPath, 0755); err != nil {
+		return "", nil, err
+	}
+	file, err := os.CreateTemp(cfg.Postgres.BackupPath, downloadPrefix+"*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	file.Close()
+	cleanup := func() {
+		if err := os.Remove(file.Name()); err != nil && !os.IsNotExist(err) {
+			cfg.Log.Warn("⚠️ Failed to delete downloaded backup", "file", file.Name(), "error", err)
+		}
+	}
+
+	cfg.Log.Info("☁️ Downloading backup from S3", "key", entry.Key)
+	if err := stree.DownloadFileFromS3(ctx, cfg.S3Client, entry.Bucket, entry.Key, file.Name()); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return file.Name(), cleanup, nil
+}
+
+// OpenBackup opens a stored backup for reading and returns its size
+func OpenBackup(ctx context.Context, cfg *config.Config, entry catalog.Entry) (io.ReadCloser, int64, error) {
+	if entry.Location == catalog.LocationLocal {
+		file, err := os.Open(entry.Path)
+		if err != nil {
+			return nil, 0, fmt.Errorf("local backup is not available: %w", err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, 0, err
+		}
+		return file, info.Size(), nil
+	}
+
+	if cfg.S3Client == nil {
+		return nil, 0, fmt.Errorf("backup %s is stored in S3, but S3 is not configured", entry.ID)
+	}
+	return stree.OpenFileFromS3(ctx, cfg.S3Client, entry.Bucket, entry.Key)
+}
+
+// RestoreBackup restores a backup into the job's Postgres server with pg_restore
+func RestoreBackup(ctx context.Context, cfg *config.Config, job *config.Job, entry catalog.Entry, opts RestoreOptions) error {
+	if IsBaseBackup(entry) {
+		return fmt.Errorf("backup %s is a physical base backup, pg_restore can't restore it: "+
+			"extract base.tar and pg_wal.tar into an empty data directory instead", entry.ID)
+	}
+	target := opts.TargetDB
+	if target == "" {
+		target = job.Postgres.Dbname
+	}
+	cfg.Log.Info("♻️ Starting restore", "job", job.Name, "backup", entry.ID, "target", target)
+
+	filePath, cleanup, err := FetchBackup(ctx, cfg, entry)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	args := []string{
+		"-U", job.Postgres.User,
+		"-h", job.Postgres.Host,
+		"-p", job.Postgres.Port,
+		"-d", target,
+		"--no-owner",
+	}
+	if opts.Clean {
+		args = append(args, "--clean", "--if-exists")
+	}
+	args = append(args, filePath)
+
+	pgRestore, err := ClientBinary(ctx, cfg, job, "pg_restore")
+	if err != nil {
+		return fmt.Errorf("❌ Error selecting pg_restore: %w", err)
+	}
+	cmd := command(ctx, pgRestore.Path, args...)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+job.Postgres.Password)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("❌ Error restoring backup: %v\n%s", err, string(output))
+	}
+
+	cfg.Log.Info("✅ Backup restored successfully", "job", job.Name, "backup", entry.ID, "target", target)
+	return nil
+}
+
+// VerifyBackup checks that a backup exists, has the cataloged size and is a readable archive
+func VerifyBackup(ctx context.Context, cfg *config.Config, entry catalog.Entry) error {
+	cfg.Log.Info("🔍 Verifying backup", "backup", entry.ID, "key", entry.Key)
+
+	filePath, cleanup, err := FetchBackup(ctx, cfg, entry)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if entry.Size > 0 && info.Size() != entry.Size {
+		return fmt.Errorf("backup size is %d bytes, catalog says %d", info.Size(), entry.Size)
+	}
+	if entry.SHA256 != "" {
+		sum, err := fileSHA256(filePath)
+		if err != nil {
+			return err
+		}
+		if sum != entry.SHA256 {
+			return fmt.Errorf("backup checksum is %s, catalog says %s", sum, entry.SHA256)
+		}
+	}
+
+	if IsBaseBackup(entry) {
+		if err := verifyBaseBackup(filePath); err != nil {
+			return err
+		}
+		cfg.Log.Info("✅ Backup verified", "backup", entry.ID)
+		return nil
+	}
+
+	// Newer pg_restore releases read archives of every older pg_dump
+	pgRestore, err := newestBinary(cfg, "pg_restore")
+	if err != nil {
+		return err
+	}
+	output, err := command(ctx, pgRestore.Path, "--list", filePath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("backup archive is not readable: %v\n%s", err, string(output))
+	}
+
+	cfg.Log.Info("✅ Backup verified", "backup", entry.ID)
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package healthcheck
+
+import "errors"
+
+// freeSpace is not supported on this platform
+func freeSpace(string) (uint64, error) {
+	return 0, errors.New("free disk space is not supported on this platform")
+}
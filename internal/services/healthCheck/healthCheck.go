@@ -1,103 +1,242 @@
 package healthcheck
 
 import (
+	"PostgresDump/internal/catalog"
 	"PostgresDump/internal/config"
 	"PostgresDump/internal/services/backups"
-	"PostgresDump/pkg/email"
+	"PostgresDump/pkg/notify"
 	"PostgresDump/pkg/stree"
+	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	v "github.com/spf13/viper"
+	"github.com/google/uuid"
 	"log"
+	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	_ "github.com/lib/pq"
 )
 
-// HealthCheck performs connection and operation checks
-func HealthCheck(cfg *config.Config) error {
+// preflightTimeout bounds all preflight checks together
+const preflightTimeout = time.Minute
+
+// probePrefix is where the S3 write probe is stored, outside of any backup key
+const probePrefix = "_pgsnapsafe/probe-"
+
+// HealthCheck runs the preflight checks, logs every result and fails when any check failed
+func HealthCheck(ctx context.Context, cfg *config.Config) error {
 	log.Println("🩺 Starting service health check...")
 
-	// 1️⃣ Check PostgreSQL connection
-	log.Println("🛢 Checking PostgreSQL connection...")
-	db, err := sql.Open("postgres", fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		cfg.Postgres.Host, cfg.Postgres.Port, cfg.Postgres.User, cfg.Postgres.Password, cfg.Postgres.Dbname,
-	))
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	var failed []string
+	for _, c := range Preflight(ctx, cfg) {
+		if c.OK {
+			log.Printf("✅ %s: %s", c.Name, c.Detail)
+			continue
+		}
+		log.Printf("❌ %s: %s", c.Name, c.Error)
+		failed = append(failed, c.Name)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed checks: %s", strings.Join(failed, ", "))
+	}
+
+	log.Println("🎉 All checks passed successfully!")
+	return nil
+}
+
+// Preflight checks everything a backup needs without creating one: the
+// connection and privileges of every job's database, a pg_dump or
+// pg_basebackup compatible with its server, an S3 write/read/delete probe,
+// free disk space and SMTP
+func Preflight(ctx context.Context, cfg *config.Config) []Check {
+	var probes []probe
+	for _, job := range cfg.Jobs {
+		probes = append(probes,
+			probe{"postgres:" + job.Name, func(ctx context.Context) (string, error) { return checkPostgres(ctx, job) }})
+		if job.Backup.Type == config.BackupPhysical {
+			probes = append(probes,
+				probe{"replication:" + job.Name, func(ctx context.Context) (string, error) { return checkReplication(ctx, job) }},
+				probe{"pg_basebackup:" + job.Name, func(ctx context.Context) (string, error) {
+					return checkClientVersion(ctx, cfg, job, "pg_basebackup")
+				}},
+			)
+			continue
+		}
+		probes = append(probes,
+			probe{"privileges:" + job.Name, func(ctx context.Context) (string, error) { return checkPrivileges(ctx, job) }},
+			probe{"pg_dump:" + job.Name, func(ctx context.Context) (string, error) { return checkClientVersion(ctx, cfg, job, "pg_dump") }},
+		)
+	}
+	if cfg.S3Client != nil {
+		probes = append(probes, probe{"storage:s3", func(ctx context.Context) (string, error) { return checkS3Probe(ctx, cfg) }})
+	}
+	probes = append(probes, probe{"disk:local", func(context.Context) (string, error) { return checkDiskSpace(cfg) }})
+	if cfg.SMTPClient != nil {
+		probes = append(probes, probe{"smtp", func(ctx context.Context) (string, error) { return checkSMTP(ctx, cfg) }})
+	}
+	return runProbes(ctx, probes)
+}
+
+func checkPostgres(ctx context.Context, job *config.Job) (string, error) {
+	db, err := sql.Open("postgres", job.Postgres.DSN())
 	if err != nil {
-		return fmt.Errorf("❌ Error connecting to PostgreSQL: %w", err)
+		return "", err
 	}
 	defer db.Close()
 
-	err = db.Ping()
+	var version string
+	if err := db.QueryRowContext(ctx, "SHOW server_version").Scan(&version); err != nil {
+		return "", fmt.Errorf("PostgreSQL is unavailable: %w", err)
+	}
+	return fmt.Sprintf("%s@%s:%s/%s, PostgreSQL %s", job.Postgres.User, job.Postgres.Host,
+		job.Postgres.Port, job.Postgres.Dbname, version), nil
+}
+
+// unreadableQuery lists the tables and sequences pg_dump can't read
+const unreadableQuery = `
+SELECT n.nspname || '.' || c.relname, count(*) OVER ()
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p', 'S')
+  AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+  AND n.nspname NOT LIKE 'pg\_toast%'
+  AND n.nspname NOT LIKE 'pg\_temp%'
+  AND (NOT has_schema_privilege(n.oid, 'USAGE') OR NOT has_table_privilege(c.oid, 'SELECT'))
+ORDER BY 1
+LIMIT 5`
+
+// checkPrivileges verifies that the backup user can read every table and sequence
+func checkPrivileges(ctx context.Context, job *config.Job) (string, error) {
+	db, err := sql.Open("postgres", job.Postgres.DSN())
 	if err != nil {
-		return fmt.Errorf("❌ PostgreSQL is unavailable: %w", err)
+		return "", err
 	}
-	log.Println("✅ PostgreSQL connection successful")
+	defer db.Close()
 
-	// 2️⃣ Check S3 connection
-	if cfg.S3Client != nil {
-		log.Println("☁️ Checking S3 connection...")
-		_, err := cfg.S3Client.ListBuckets(context.TODO(), &s3.ListBucketsInput{})
-		if err != nil {
-			return fmt.Errorf("❌ Error connecting to S3: %w", err)
+	rows, err := db.QueryContext(ctx, unreadableQuery)
+	if err != nil {
+		return "", fmt.Errorf("failed to check privileges: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	var total int
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name, &total); err != nil {
+			return "", err
 		}
-		log.Println("✅ S3 connection successful")
-	} else {
-		log.Println("⚠️ S3 not initialized, skipping test")
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
 	}
+	if total > 0 {
+		return "", fmt.Errorf("user %s can't read %d tables or sequences: %s",
+			job.Postgres.User, total, strings.Join(names, ", "))
+	}
+	return fmt.Sprintf("user %s can read every table and sequence", job.Postgres.User), nil
+}
 
-	// 3️⃣ Create a test backup
-	log.Println("🛠 Creating test backup...")
-	testBackup, err := backups.CreateBackup(cfg)
+// checkClientVersion verifies that an installed client program, pg_dump or
+// pg_basebackup, supports the server
+func checkClientVersion(ctx context.Context, cfg *config.Config, job *config.Job, name string) (string, error) {
+	major, err := backups.ServerMajor(ctx, job)
 	if err != nil {
-		return fmt.Errorf("❌ Error creating test backup: %w", err)
+		return "", fmt.Errorf("failed to read server version: %w", err)
 	}
-	log.Println("✅ Test backup successfully created:", testBackup)
+	b, err := backups.ClientBinary(ctx, cfg, job, name)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s (%s) for PostgreSQL %d", name, b.Version, b.Path, major), nil
+}
 
-	// Wait a couple of seconds to ensure the backup is uploaded to S3
-	time.Sleep(30 * time.Second)
+// checkReplication verifies that the backup user may open the replication
+// connections pg_basebackup needs and that the server accepts them
+func checkReplication(ctx context.Context, job *config.Job) (string, error) {
+	db, err := sql.Open("postgres", job.Postgres.DSN())
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
 
-	// 4️⃣ Check if the backup appeared in S3
-	if cfg.S3Client != nil {
-		log.Println("🔍 Checking for test backup in S3...")
-		files, err := stree.ListFilesInS3Directory(cfg.S3Client, cfg.BucketName, cfg.Postgres.BackupPath)
-		if err != nil {
-			return fmt.Errorf("❌ Error checking backup in S3: %w", err)
-		}
+	var allowed bool
+	var senders int
+	err = db.QueryRowContext(ctx, `SELECT rolreplication OR rolsuper, current_setting('max_wal_senders')::int
+		FROM pg_roles WHERE rolname = current_user`).Scan(&allowed, &senders)
+	if err != nil {
+		return "", fmt.Errorf("failed to check replication privileges: %w", err)
+	}
+	if !allowed {
+		return "", fmt.Errorf("user %s has no REPLICATION privilege", job.Postgres.User)
+	}
+	// pg_basebackup with streamed WAL uses two connections
+	if senders < 2 {
+		return "", fmt.Errorf("max_wal_senders is %d, pg_basebackup with streamed WAL needs 2", senders)
+	}
+	return fmt.Sprintf("user %s may replicate, max_wal_senders is %d", job.Postgres.User, senders), nil
+}
 
-		found := false
-		for _, file := range files {
-			if file == testBackup {
-				found = true
-				break
-			}
-		}
+// checkS3Probe writes, reads back and deletes a small object in the bucket
+func checkS3Probe(ctx context.Context, cfg *config.Config) (string, error) {
+	key := probePrefix + uuid.NewString()
+	data := []byte("pgsnapsafe preflight " + time.Now().UTC().Format(time.RFC3339))
 
-		if !found {
-			return fmt.Errorf("❌ Test backup not found in S3")
-		}
-		log.Println("✅ Test backup found in S3")
+	if err := stree.WriteFileToS3(ctx, cfg.S3Client, cfg.BucketName, key, data); err != nil {
+		return "", err
 	}
+	read, readErr := stree.ReadFileFromS3(ctx, cfg.S3Client, cfg.BucketName, key)
+	if err := stree.DeleteFileFromS3(ctx, cfg.S3Client, cfg.BucketName, key); err != nil {
+		return "", fmt.Errorf("probe object %s was written but not deleted: %w", key, err)
+	}
+	if readErr != nil {
+		return "", readErr
+	}
+	if !bytes.Equal(read, data) {
+		return "", errors.New("probe object read back with different content")
+	}
+	return fmt.Sprintf("bucket %s is writable, readable and deletable", cfg.BucketName), nil
+}
 
-	// 5️⃣ Delete test backup from S3
-	if cfg.S3Client != nil {
-		log.Println("🗑 Deleting test backup from S3...")
-		err := stree.DeleteFileFromS3(cfg.S3Client, cfg.BucketName, testBackup)
-		if err != nil {
-			return fmt.Errorf("❌ Error deleting test backup from S3: %w", err)
-		}
-		log.Println("✅ Test backup successfully deleted from S3")
+// checkDiskSpace verifies that the backup directory has room for the newest
+// backup of every job, plus 10%
+func checkDiskSpace(cfg *config.Config) (string, error) {
+	free, err := freeSpace(cfg.Postgres.BackupPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read free space of %s: %w", cfg.Postgres.BackupPath, err)
 	}
 
-	if v.GetBool("smtp") {
-		if err = email.SendEmail(cfg.SMTPClient, v.GetString("email_delivery"), testBackup); err != nil {
-			cfg.Log.Error("Error sending email", "error", err)
+	var needed int64
+	for _, job := range cfg.Jobs {
+		if entries := cfg.Catalog.List(catalog.Filter{Job: job.Name}); len(entries) > 0 {
+			needed += entries[0].Size
+			// A base backup is bundled next to the files of pg_basebackup
+			if job.Backup.Type == config.BackupPhysical {
+				needed += entries[0].Size
+			}
 		}
 	}
+	needed += needed / 10
 
-	log.Println("🎉 All checks passed successfully!")
-	return nil
+	detail := fmt.Sprintf("%s free in %s", notify.FormatSize(int64(free)), cfg.Postgres.BackupPath)
+	if uint64(needed) > free {
+		return "", fmt.Errorf("%s, the newest backups need %s", detail, notify.FormatSize(needed))
+	}
+	if needed > 0 {
+		detail += fmt.Sprintf(", the newest backups need %s", notify.FormatSize(needed))
+	}
+	return detail, nil
+}
+
+func checkSMTP(ctx context.Context, cfg *config.Config) (string, error) {
+	if err := cfg.SMTPClient.Check(ctx); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d", cfg.SMTPClient.Host, cfg.SMTPClient.Port), nil
 }
@@ -0,0 +1,132 @@
+package healthcheck
+
+import (
+	"PostgresDump/internal/catalog"
+	"PostgresDump/internal/config"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Check is the outcome of a single check
+type Check struct {
+	Name     string        `json:"name"`
+	OK       bool          `json:"ok"`
+	Detail   string        `json:"detail,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Readiness checks that every job's database is reachable, storage is writable
+// and the newest backups are within their max_age. Jobs without backups pass
+// the age check until max_age has passed since started.
+func Readiness(ctx context.Context, cfg *config.Config, started time.Time) []Check {
+	probes := []probe{
+		{"storage:local", func(context.Context) (string, error) { return "", checkLocalStorage(cfg) }},
+	}
+	if cfg.S3Client != nil {
+		probes = append(probes, probe{"storage:s3", func(ctx context.Context) (string, error) { return "", checkS3(ctx, cfg) }})
+	}
+	for _, job := range cfg.Jobs {
+		probes = append(probes, probe{"postgres:" + job.Name, func(ctx context.Context) (string, error) { return "", pingPostgres(ctx, job) }})
+		if job.Backup.MaxAge > 0 {
+			probes = append(probes, probe{"backup_age:" + job.Name, func(context.Context) (string, error) { return "", checkBackupAge(cfg, job, started) }})
+		}
+	}
+	return runProbes(ctx, probes)
+}
+
+// probe is a named check, run returns a description of what was found
+type probe struct {
+	name string
+	run  func(context.Context) (string, error)
+}
+
+// runProbes runs the probes concurrently and returns their results in order
+func runProbes(ctx context.Context, probes []probe) []Check {
+	results := make([]Check, len(probes))
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func(i int, p probe) {
+			defer wg.Done()
+			start := time.Now()
+			detail, err := p.run(ctx)
+			results[i] = Check{Name: p.name, OK: err == nil, Detail: detail, Duration: time.Since(start)}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, p)
+	}
+	wg.Wait()
+	return results
+}
+
+func pingPostgres(ctx context.Context, job *config.Job) error {
+	db, err := sql.Open("postgres", job.Postgres.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.PingContext(ctx)
+}
+
+// checkLocalStorage creates and removes a file in the backup directory
+func checkLocalStorage(cfg *config.Config) error {
+	file, err := os.CreateTemp(cfg.Postgres.BackupPath, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("backup directory is not writable: %w", err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// s3ProbeInterval is how often checkS3 writes a probe object, probes run
+// every few seconds and in between only check that the bucket is reachable
+const s3ProbeInterval = 10 * time.Minute
+
+// lastS3Probe is when checkS3 last wrote, read and deleted a probe object
+var lastS3Probe struct {
+	sync.Mutex
+	at time.Time
+}
+
+// checkS3 checks that the bucket is reachable and, every s3ProbeInterval,
+// that backups can be written to and deleted from it
+func checkS3(ctx context.Context, cfg *config.Config) error {
+	_, err := cfg.S3Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(cfg.BucketName)})
+	if err != nil {
+		return fmt.Errorf("bucket %s is not accessible: %w", cfg.BucketName, err)
+	}
+
+	lastS3Probe.Lock()
+	defer lastS3Probe.Unlock()
+	if time.Since(lastS3Probe.at) < s3ProbeInterval {
+		return nil
+	}
+	if _, err := checkS3Probe(ctx, cfg); err != nil {
+		return fmt.Errorf("bucket %s is not writable: %w", cfg.BucketName, err)
+	}
+	lastS3Probe.at = time.Now()
+	return nil
+}
+
+func checkBackupAge(cfg *config.Config, job *config.Job, started time.Time) error {
+	entries := cfg.Catalog.List(catalog.Filter{Job: job.Name})
+	if len(entries) == 0 {
+		if time.Since(started) > job.Backup.MaxAge {
+			return fmt.Errorf("no backups within max_age %s", job.Backup.MaxAge)
+		}
+		return nil
+	}
+	if age := time.Since(entries[0].CreatedAt); age > job.Backup.MaxAge {
+		return fmt.Errorf("newest backup is %s old, max_age is %s", age.Round(time.Minute), job.Backup.MaxAge)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package hooks
+
+import (
+	"PostgresDump/internal/config"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	_ "github.com/lib/pq"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxOutput is how much of a hook's output ends up in the log and in errors
+const maxOutput = 2000
+
+// waitDelay is how long a command that timed out may keep its output open
+const waitDelay = 5 * time.Second
+
+// Run describes the backup run the hooks are called for
+type Run struct {
+	// Status is running before the backup, success or warning after it and failure after a failed run
+	Status string
+	// File is where the backup is stored, as an S3 key or a local path
+	File     string
+	Key      string
+	Size     int64
+	SHA256   string
+	Error    string
+	Duration time.Duration
+}
+
+// Error is a failed hook whose on_error is abort
+type Error struct {
+	Stage string
+	Hook  string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s hook %q failed: %v", e.Stage, e.Hook, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// RunStage runs the job's hooks of a stage in order. A failing hook with
+// on_error abort stops the stage and is returned as *Error, the failures of
+// the other hooks are returned as warnings.
+func RunStage(ctx context.Context, cfg *config.Config, job *config.Job, stage string, run Run) ([]string, error) {
+	var warnings []string
+	for _, hook := range job.Backup.Hooks.Stage(stage) {
+		cfg.Log.Info("🪝 Running hook", "job", job.Name, "stage", stage, "hook", hook.Name)
+		started := time.Now()
+
+		output, err := runHook(ctx, job, stage, hook, run)
+		if err == nil {
+			cfg.Log.Info("✅ Hook finished", "job", job.Name, "stage", stage, "hook", hook.Name,
+				"duration", time.Since(started).String(), "output", output)
+			continue
+		}
+		if output != "" {
+			err = fmt.Errorf("%w\n%s", err, output)
+		}
+		if hook.OnError == config.HookAbort {
+			cfg.Log.Error("❌ Hook failed, aborting the run", "job", job.Name, "stage", stage, "hook", hook.Name, "error", err)
+			return warnings, &Error{Stage: stage, Hook: hook.Name, Err: err}
+		}
+		cfg.Log.Warn("⚠️ Hook failed", "job", job.Name, "stage", stage, "hook", hook.Name, "error", err)
+		warnings = append(warnings, fmt.Sprintf("%s hook %q failed: %v", stage, hook.Name, err))
+	}
+	return warnings, nil
+}
+
+func runHook(ctx context.Context, job *config.Job, stage string, hook config.Hook, run Run) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+
+	var output string
+	var err error
+	if hook.SQL != "" {
+		err = runSQL(ctx, job, hook, settings(job, stage, run))
+	} else {
+		output, err = runCommand(ctx, hook, environment(job, stage, run))
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s: %w", hook.Timeout, err)
+	}
+	return output, err
+}
+
+// runCommand runs a hook command with the variables of the run in its
+// environment, a timed out command is killed with its child processes
+func runCommand(ctx context.Context, hook config.Hook, env []string) (string, error) {
+	cmd := shellCommand(ctx, hook.Command)
+	cmd.Env = append(append(os.Environ(), env...), hook.Env...)
+	cmd.WaitDelay = waitDelay
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	return tail(output.String()), err
+}
+
+// runSQL runs a hook statement on the job's database. The variables of the run
+// are available through current_setting('pgsnapsafe.<name>').
+func runSQL(ctx context.Context, job *config.Job, hook config.Hook, settings map[string]string) error {
+	db, err := sql.Open("postgres", job.Postgres.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for name, value := range settings {
+		if _, err := conn.ExecContext(ctx, "SELECT set_config($1, $2, false)", "pgsnapsafe."+name, value); err != nil {
+			return fmt.Errorf("setting pgsnapsafe.%s: %w", name, err)
+		}
+	}
+	_, err = conn.ExecContext(ctx, hook.SQL)
+	return err
+}
+
+// settings returns the variables of the run by their lowercase name
+func settings(job *config.Job, stage string, run Run) map[string]string {
+	return map[string]string{
+		"job":      job.Name,
+		"stage":    stage,
+		"status":   run.Status,
+		"cluster":  job.Backup.Cluster,
+		"host":     job.Postgres.Host,
+		"database": job.Postgres.Dbname,
+		"file":     run.File,
+		"key":      run.Key,
+		"size":     strconv.FormatInt(run.Size, 10),
+		"sha256":   run.SHA256,
+		"error":    run.Error,
+		"duration": strconv.FormatFloat(run.Duration.Seconds(), 'f', 3, 64),
+	}
+}
+
+// environment returns the variables of the run as PGSNAPSAFE_* variables and
+// the job's connection as the PG* variables of libpq
+func environment(job *config.Job, stage string, run Run) []string {
+	var env []string
+	for name, value := range settings(job, stage, run) {
+		env = append(env, "PGSNAPSAFE_"+strings.ToUpper(name)+"="+value)
+	}
+	return append(env,
+		"PGHOST="+job.Postgres.Host,
+		"PGPORT="+job.Postgres.Port,
+		"PGUSER="+job.Postgres.User,
+		"PGPASSWORD="+job.Postgres.Password,
+		"PGDATABASE="+job.Postgres.Dbname,
+	)
+}
+
+// tail returns the end of a hook's output
+func tail(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) > maxOutput {
+		output = "..." + output[len(output)-maxOutput:]
+	}
+	return output
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package hooks
+
+import (
+	"context"
+	"os/exec"
+)
+
+// shellCommand runs command with cmd, a canceled hook only kills cmd itself here
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "cmd", "/C", command)
+}
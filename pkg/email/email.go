@@ -1,85 +1,170 @@
 package email
 
 import (
-	"bytes"
+	"PostgresDump/pkg/notify"
+	"context"
 	"fmt"
-	v "github.com/spf13/viper"
 	"gopkg.in/gomail.v2"
-	"html/template"
 	"log"
-	"path/filepath"
+	"strings"
 	"time"
 )
 
-// SendEmail - Function to send an email
-func SendEmail(smtClient *SMTPClient, email, filename string) error {
-	htmlBody, textBody, err := generateEmail(filename)
+// Recipients are the addresses an email is delivered to
+type Recipients struct {
+	To  []string
+	Cc  []string
+	Bcc []string
+}
+
+// All returns every envelope recipient
+func (r Recipients) All() []string {
+	all := append([]string{}, r.To...)
+	all = append(all, r.Cc...)
+	return append(all, r.Bcc...)
+}
+
+// ParseAddresses splits comma-separated entries and drops empty ones
+func ParseAddresses(entries []string) []string {
+	var addrs []string
+	for _, entry := range entries {
+		for _, addr := range strings.Split(entry, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs
+}
+
+// Notifier sends reports by email, it implements notify.Notifier
+type Notifier struct {
+	Client     *SMTPClient
+	Recipients Recipients
+}
+
+func (n *Notifier) Notify(ctx context.Context, report notify.Report) error {
+	return SendReport(ctx, n.Client, n.Recipients, report)
+}
+
+func (n *Notifier) NotifyDigest(ctx context.Context, d notify.Digest) error {
+	return SendDigest(ctx, n.Client, n.Recipients, d)
+}
+
+// SendReport - Function to send the notification for a backup run event
+func SendReport(ctx context.Context, smtClient *SMTPClient, email Recipients, report notify.Report) error {
+	htmlBody, textBody, subject, err := generateEmail(report)
 	if err != nil {
 		return err
 	}
 
-	// Sending HTML email for other domains
-	return sendHTMLEmail(smtClient, email, textBody, htmlBody)
+	return sendMultipartEmail(ctx, smtClient, email, subject, textBody, htmlBody)
 }
 
-// Generate email for verification
-func generateEmail(filename string) (htmlBody, textBody string, err error) {
-	var htmlFileName string
-	if v.GetString("email_lang") == "ru" {
-		htmlFileName = "ru.html"
-	} else {
-		htmlFileName = "en.html"
+// SendDigest - Function to send a digest of the runs of a period
+func SendDigest(ctx context.Context, smtClient *SMTPClient, email Recipients, d notify.Digest) error {
+	lang := emailLang()
+	l, err := loadLocale(lang)
+	if err != nil {
+		return err
 	}
 
-	htmlBody, err = renderTemplate(htmlFileName, struct {
-		FileName  string
-		Timestamp string
+	htmlBody, textBody, err := render(l, digestTemplate, digestTemplate, struct {
+		notify.Digest
+		Lang     string
+		Title    string
+		Color    string
+		Year     int
+		FromText string
+		ToText   string
 	}{
-		FileName:  filename,
-		Timestamp: time.Now().Format("2006-01-02 15:04:05"),
+		Digest:   d,
+		Lang:     lang,
+		Title:    l.t("digest.title." + d.Period),
+		Color:    colors[digestTemplate],
+		Year:     d.To.Year(),
+		FromText: d.From.Format("2006-01-02 15:04"),
+		ToText:   d.To.Format("2006-01-02 15:04"),
 	})
-
 	if err != nil {
-		return "", "", fmt.Errorf("error generating HTML for email confirmation: %w", err)
+		return fmt.Errorf("error generating %s digest: %w", d.Period, err)
 	}
-	textBody = "Backup created successfully"
-	return htmlBody, textBody, nil
+
+	subject := subject(l, digestTemplate, "subject.digest."+d.Period, map[string]string{
+		"date":   d.To.Format("2006-01-02"),
+		"period": d.Period,
+	})
+	return sendMultipartEmail(ctx, smtClient, email, subject, textBody, htmlBody)
 }
 
-// Render HTML template
-func renderTemplate(fileName string, data interface{}) (string, error) {
-	templatePath := filepath.Join("pkg/email/template", fileName)
-	tmpl, err := template.ParseFiles(templatePath)
+// Generate email for the report event
+func generateEmail(report notify.Report) (htmlBody, textBody, subj string, err error) {
+	lang := emailLang()
+	l, err := loadLocale(lang)
 	if err != nil {
-		log.Printf("Error loading template: %v", err)
-		return "", err
+		return "", "", "", err
 	}
 
-	var body bytes.Buffer
-	err = tmpl.Execute(&body, data)
+	event := string(report.Event)
+	htmlBody, textBody, err = render(l, event, "report", struct {
+		notify.Report
+		Lang         string
+		Title        string
+		Color        string
+		Year         int
+		Timestamp    string
+		DurationText string
+		SizeText     string
+		ExpiresText  string
+	}{
+		Report:       report,
+		Lang:         lang,
+		Title:        l.t(event + ".title"),
+		Color:        colors[event],
+		Year:         report.Time.Year(),
+		Timestamp:    report.Time.Format("2006-01-02 15:04:05"),
+		DurationText: report.Duration.Round(time.Second).String(),
+		SizeText:     notify.FormatSize(report.Size),
+		ExpiresText:  report.DownloadExpires.Format("2006-01-02 15:04"),
+	})
 	if err != nil {
-		log.Printf("Error processing template: %v", err)
-		return "", err
+		return "", "", "", fmt.Errorf("error generating %s notification: %w", report.Event, err)
 	}
 
-	return body.String(), nil
+	name := report.Job
+	if name == "" {
+		name = report.FileName
+	}
+	subj = subject(l, event, "subject."+event, map[string]string{
+		"job":  name,
+		"db":   report.Database,
+		"host": report.Host,
+		"date": report.Time.Format("2006-01-02"),
+	})
+	return htmlBody, textBody, subj, nil
 }
 
-// sendHTMLEmail - Send HTML email
-func sendHTMLEmail(smtpClient *SMTPClient, email, subject, body string) error {
+// sendMultipartEmail - Send email with plain text and HTML alternatives
+func sendMultipartEmail(ctx context.Context, smtpClient *SMTPClient, email Recipients, subject, text, html string) error {
 	m := gomail.NewMessage()
-	m.SetHeader("From", smtpClient.Sender)
-	m.SetHeader("To", email)
+	m.SetHeader("From", m.FormatAddress(smtpClient.FromAddress(), smtpClient.Sender))
+	if len(email.To) > 0 {
+		m.SetHeader("To", email.To...)
+	}
+	if len(email.Cc) > 0 {
+		m.SetHeader("Cc", email.Cc...)
+	}
 	m.SetHeader("Subject", subject)
-	m.SetBody("text/html", body)
+	m.SetDateHeader("Date", time.Now())
+	m.SetBody("text/plain", text)
+	m.AddAlternative("text/html", html)
 
-	d := gomail.NewDialer(smtpClient.Host, smtpClient.Port, smtpClient.Username, smtpClient.Password)
-	err := d.DialAndSend(m)
-	if err != nil {
-		log.Printf("Error sending HTML email: %v", err)
+	// Bcc recipients are only part of the envelope, never of the headers
+	if err := smtpClient.Send(ctx, email.All(), m); err != nil {
+		log.Printf("Error sending email: %v", err)
 		return err
 	}
 
-	log.Printf("HTML email successfully sent to %s", email)
+	log.Printf("Email successfully sent to %s", strings.Join(email.All(), ", "))
 	return nil
 }
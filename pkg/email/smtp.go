@@ -1,19 +1,69 @@
 package email
 
 import (
+	"PostgresDump/pkg/retry"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TLS modes of the SMTP connection
+const (
+	// TLSNone never encrypts the connection
+	TLSNone = "none"
+	// TLSStartTLS upgrades the connection when the server offers STARTTLS and
+	// sends in cleartext when it doesn't
+	TLSStartTLS = "starttls"
+	// TLSStartTLSRequired fails when the server doesn't offer STARTTLS
+	TLSStartTLSRequired = "starttls-required"
+	// TLSImplicit speaks TLS from the first byte, usually on port 465
+	TLSImplicit = "implicit"
+)
 
-	"gopkg.in/gomail.v2"
+// Authentication mechanisms. AuthAuto picks the strongest mechanism the server
+// offers, or no authentication without a username.
+const (
+	AuthAuto    = "auto"
+	AuthNone    = "none"
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
 )
 
+// TLSModes and AuthMechanisms list the accepted option values
+var (
+	TLSModes       = []string{TLSNone, TLSStartTLS, TLSStartTLSRequired, TLSImplicit}
+	AuthMechanisms = []string{AuthAuto, AuthNone, AuthPlain, AuthLogin, AuthCRAMMD5}
+)
+
+const defaultTimeout = 30 * time.Second
+
 type SMTPClient struct {
 	Host     string
 	Port     int
 	Username string
 	Password string
 	Sender   string
+	// From is the sender address, Username when empty
+	From string
+	// TLS is one of TLSModes, see DefaultTLS when empty
+	TLS string
+	// RootCAs verifies the server certificate, the system pool when nil
+	RootCAs *x509.CertPool
+	// Auth is one of AuthMechanisms, AuthAuto when empty
+	Auth string
+	// Timeout bounds connecting and the whole SMTP session
+	Timeout time.Duration
 }
 
 // NewSMTPClient - Creating a new SMTP client
@@ -24,31 +74,261 @@ func NewSMTPClient(host string, port int, username, password, sender string) *SM
 		Username: username,
 		Password: password,
 		Sender:   sender,
+		TLS:      DefaultTLS(host, port),
+		Auth:     AuthAuto,
+		Timeout:  defaultTimeout,
 	}
 }
 
-func (s *SMTPClient) SendEmail(to, subject, body string) error {
-	// Creating a new message
-	m := gomail.NewMessage()
-	m.SetHeader("From", s.Username)
-	m.SetHeader("To", to)
-	m.SetHeader("Subject", subject)
-	m.SetBody("text/html", body)
-
-	// Setting up TLS
-	d := gomail.NewDialer(s.Host, s.Port, s.Username, s.Password)
-	d.TLSConfig = &tls.Config{
-		ServerName:         s.Host, // Server name verification
-		InsecureSkipVerify: false,  // Disabling insecure mode
+// DefaultTLS returns the TLS mode used when none is configured: implicit TLS
+// on port 465 like the SSL port of older releases, opportunistic STARTTLS for
+// a relay on localhost and required STARTTLS everywhere else, so that mail
+// never falls back to cleartext unnoticed
+func DefaultTLS(host string, port int) string {
+	switch {
+	case port == 465:
+		return TLSImplicit
+	case isLocalhost(host):
+		return TLSStartTLS
 	}
+	return TLSStartTLSRequired
+}
 
-	// Attempting to send the email
-	err := d.DialAndSend(m)
+// LoadCAFile trusts the PEM certificates in path instead of the system pool
+func (s *SMTPClient) LoadCAFile(path string) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Printf("Error sending email to %s: %v", to, err)
-		return fmt.Errorf("failed to send email to %s: %w", to, err)
+		return fmt.Errorf("failed to read SMTP CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in SMTP CA bundle %s", path)
 	}
+	s.RootCAs = pool
+	return nil
+}
 
-	log.Printf("Email successfully sent to %s", to)
+// Validate checks the TLS mode and authentication mechanism
+func (s *SMTPClient) Validate() error {
+	if s.TLS != "" && !contains(TLSModes, s.TLS) {
+		return fmt.Errorf("unknown SMTP TLS mode %q, expected one of %v", s.TLS, TLSModes)
+	}
+	if s.Auth != "" && !contains(AuthMechanisms, s.Auth) {
+		return fmt.Errorf("unknown SMTP auth mechanism %q, expected one of %v", s.Auth, AuthMechanisms)
+	}
 	return nil
 }
+
+// FromAddress returns the envelope sender
+func (s *SMTPClient) FromAddress() string {
+	if s.From != "" {
+		return s.From
+	}
+	return s.Username
+}
+
+// Send delivers a message to every recipient in a single SMTP session,
+// canceling ctx closes the connection. Failed sends are retried by the notify
+// phase of a run, except for permanent rejections (5xx replies).
+func (s *SMTPClient) Send(ctx context.Context, to []string, msg io.WriterTo) error {
+	if len(to) == 0 {
+		return retry.Permanent(errors.New("no email recipients"))
+	}
+	err := s.send(ctx, to, msg)
+	if permanent(err) {
+		return retry.Permanent(err)
+	}
+	return err
+}
+
+// Check connects to the server, negotiates TLS and authenticates without sending
+func (s *SMTPClient) Check(ctx context.Context) error {
+	c, stop, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	defer c.Close()
+	return c.Quit()
+}
+
+func (s *SMTPClient) send(ctx context.Context, to []string, msg io.WriterTo) error {
+	c, stop, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	defer c.Close()
+
+	if err := c.Mail(s.FromAddress()); err != nil {
+		return fmt.Errorf("sender %s rejected: %w", s.FromAddress(), err)
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", addr, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := msg.WriteTo(w); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// dial opens an SMTP session that is encrypted and authenticated as configured.
+// The connection is closed when ctx is canceled until stop is called.
+func (s *SMTPClient) dial(ctx context.Context) (c *smtp.Client, stop func() bool, err error) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	dialer := &net.Dialer{Timeout: timeout}
+
+	mode := s.TLS
+	if mode == "" {
+		mode = DefaultTLS(s.Host, s.Port)
+	}
+
+	var conn net.Conn
+	if mode == TLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: s.tlsConfig()}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to SMTP server %s: %w", addr, err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	// The named result is nil by the time a failed dial returns
+	release := context.AfterFunc(ctx, func() { conn.Close() })
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+
+	c, err = smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if mode == TLSStartTLS || mode == TLSStartTLSRequired {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(s.tlsConfig()); err != nil {
+				c.Close()
+				return nil, nil, fmt.Errorf("STARTTLS failed: %w", err)
+			}
+		} else if mode == TLSStartTLSRequired {
+			c.Close()
+			return nil, nil, fmt.Errorf("SMTP server %s does not support STARTTLS", addr)
+		}
+	}
+
+	auth, err := s.auth(c)
+	if err == nil && auth != nil {
+		err = c.Auth(auth)
+	}
+	if err != nil {
+		c.Close()
+		return nil, nil, fmt.Errorf("SMTP authentication failed: %w", err)
+	}
+	return c, release, nil
+}
+
+func (s *SMTPClient) tlsConfig() *tls.Config {
+	return &tls.Config{
+		ServerName: s.Host,
+		RootCAs:    s.RootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// auth returns the configured mechanism, nil when authentication is disabled
+func (s *SMTPClient) auth(c *smtp.Client) (smtp.Auth, error) {
+	mechanism := s.Auth
+	if mechanism == AuthNone || (mechanism == "" || mechanism == AuthAuto) && s.Username == "" {
+		return nil, nil
+	}
+	ok, offered := c.Extension("AUTH")
+	if !ok {
+		return nil, errors.New("server does not support authentication")
+	}
+	if mechanism == "" || mechanism == AuthAuto {
+		mechanism = AuthPlain
+		for _, m := range []string{AuthCRAMMD5, AuthPlain, AuthLogin} {
+			if contains(strings.Fields(strings.ToLower(offered)), m) {
+				mechanism = m
+				break
+			}
+		}
+	}
+
+	switch mechanism {
+	case AuthPlain:
+		return smtp.PlainAuth("", s.Username, s.Password, s.Host), nil
+	case AuthLogin:
+		return &loginAuth{username: s.Username, password: s.Password, host: s.Host}, nil
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(s.Username, s.Password), nil
+	}
+	return nil, fmt.Errorf("unknown auth mechanism %q", mechanism)
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp doesn't provide.
+// Like PLAIN it only sends credentials over TLS or to localhost.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// permanent reports whether the server rejected the message for good
+func permanent(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 500
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,484 @@
+package email
+
+import (
+	"PostgresDump/pkg/retry"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testUser     = "backup@example.com"
+	testPassword = "s3cret"
+)
+
+// fakeMessage is a message received by fakeSMTP
+type fakeMessage struct {
+	from string
+	rcpt []string
+	data string
+	// tls and auth describe the session the message was sent in
+	tls  bool
+	auth string
+}
+
+// fakeSMTP is an in-process SMTP server that understands just enough of the
+// protocol for SMTPClient
+type fakeSMTP struct {
+	listener net.Listener
+	tls      *tls.Config
+	// startTLS offers STARTTLS on plain connections
+	startTLS bool
+	// auth lists the offered mechanisms, e.g. "PLAIN LOGIN CRAM-MD5"
+	auth string
+	// mailReplies answer MAIL FROM in consecutive sessions, 250 after them
+	mailReplies []string
+
+	mu       sync.Mutex
+	sessions int
+	messages []fakeMessage
+}
+
+// newFakeSMTP starts a server on a random local port, implicit makes it speak
+// TLS from the first byte. The returned pool trusts its certificate.
+func newFakeSMTP(t *testing.T, implicit bool) (*fakeSMTP, *x509.CertPool) {
+	t.Helper()
+	cert, pool := testCertificate(t)
+	s := &fakeSMTP{tls: &tls.Config{Certificates: []tls.Certificate{cert}}}
+
+	var err error
+	if implicit {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tls)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.listener.Close() })
+
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			session := s.sessions
+			s.sessions++
+			s.mu.Unlock()
+			go s.serve(conn, session, implicit)
+		}
+	}()
+	return s, pool
+}
+
+// client returns a client of the server
+func (s *fakeSMTP) client(pool *x509.CertPool, mode, auth string) *SMTPClient {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	c := NewSMTPClient("127.0.0.1", addr.Port, testUser, testPassword, "PgSnapSafe")
+	c.TLS, c.Auth, c.RootCAs = mode, auth, pool
+	c.Timeout = 5 * time.Second
+	return c
+}
+
+func (s *fakeSMTP) received() (int, []fakeMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions, append([]fakeMessage(nil), s.messages...)
+}
+
+func (s *fakeSMTP) serve(conn net.Conn, session int, secure bool) {
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 fake ESMTP")
+
+	var msg fakeMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			lines := []string{"fake"}
+			if s.startTLS && !secure {
+				lines = append(lines, "STARTTLS")
+			}
+			if s.auth != "" {
+				lines = append(lines, "AUTH "+s.auth)
+			}
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, l)
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 ready")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, secure = tlsConn, true
+			tp = textproto.NewConn(conn)
+		case "AUTH":
+			mechanism, ok := s.authenticate(tp, arg)
+			if !ok {
+				tp.PrintfLine("535 authentication failed")
+				continue
+			}
+			msg.auth = mechanism
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			if reply := s.mailReply(session); reply != "" {
+				tp.PrintfLine("%s", reply)
+				continue
+			}
+			msg.from = address(arg)
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			msg.rcpt = append(msg.rcpt, address(arg))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data, msg.tls = string(data), secure
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 unknown command")
+		}
+	}
+}
+
+func (s *fakeSMTP) mailReply(session int) string {
+	if session < len(s.mailReplies) {
+		return s.mailReplies[session]
+	}
+	return ""
+}
+
+// authenticate runs the exchange of the AUTH command
+func (s *fakeSMTP) authenticate(tp *textproto.Conn, arg string) (string, bool) {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	mechanism = strings.ToUpper(mechanism)
+	read := func(challenge string) string {
+		tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+		line, _ := tp.ReadLine()
+		decoded, _ := base64.StdEncoding.DecodeString(line)
+		return string(decoded)
+	}
+
+	switch mechanism {
+	case "PLAIN":
+		var response string
+		if initial != "" {
+			decoded, _ := base64.StdEncoding.DecodeString(initial)
+			response = string(decoded)
+		} else {
+			response = read("")
+		}
+		return mechanism, response == "\x00"+testUser+"\x00"+testPassword
+	case "LOGIN":
+		user := read("Username:")
+		password := read("Password:")
+		return mechanism, user == testUser && password == testPassword
+	case "CRAM-MD5":
+		challenge := "<1234.5678@fake>"
+		mac := hmac.New(md5.New, []byte(testPassword))
+		mac.Write([]byte(challenge))
+		return mechanism, read(challenge) == testUser+" "+hex.EncodeToString(mac.Sum(nil))
+	}
+	return mechanism, false
+}
+
+func address(arg string) string {
+	start, end := strings.IndexByte(arg, '<'), strings.IndexByte(arg, '>')
+	if start < 0 || end < start {
+		return arg
+	}
+	return arg[start+1 : end]
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a pool trusting it
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake smtp"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// testMessage is a minimal message for Send
+type testMessage string
+
+func (m testMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, string(m))
+	return int64(n), err
+}
+
+const testBody = testMessage("Subject: test\r\n\r\nhello\r\n")
+
+func TestDefaultTLS(t *testing.T) {
+	tests := []struct {
+		host string
+		port int
+		want string
+	}{
+		{host: "smtp.example.com", port: 465, want: TLSImplicit},
+		{host: "smtp.example.com", port: 587, want: TLSStartTLSRequired},
+		{host: "smtp.example.com", port: 25, want: TLSStartTLSRequired},
+		{host: "localhost", port: 25, want: TLSStartTLS},
+		{host: "127.0.0.1", port: 465, want: TLSImplicit},
+	}
+	for _, tt := range tests {
+		if got := DefaultTLS(tt.host, tt.port); got != tt.want {
+			t.Errorf("DefaultTLS(%q, %d) = %q, want %q", tt.host, tt.port, got, tt.want)
+		}
+		if got := NewSMTPClient(tt.host, tt.port, "", "", "").TLS; got != tt.want {
+			t.Errorf("NewSMTPClient(%q, %d).TLS = %q, want %q", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestSendStartTLS(t *testing.T) {
+	server, pool := newFakeSMTP(t, false)
+	server.startTLS = true
+
+	for _, mode := range []string{TLSStartTLS, TLSStartTLSRequired} {
+		if err := server.client(pool, mode, AuthNone).Send(context.Background(), []string{"ops@example.com"}, testBody); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+	}
+	_, messages := server.received()
+	for i, msg := range messages {
+		if !msg.tls {
+			t.Errorf("message %d was sent without TLS", i)
+		}
+	}
+}
+
+func TestSendStartTLSRequiredWithoutSupport(t *testing.T) {
+	server, pool := newFakeSMTP(t, false)
+
+	err := server.client(pool, TLSStartTLSRequired, AuthNone).Send(context.Background(), []string{"ops@example.com"}, testBody)
+	if err == nil || !strings.Contains(err.Error(), "does not support STARTTLS") {
+		t.Fatalf("Send = %v, want a STARTTLS error", err)
+	}
+	if _, messages := server.received(); len(messages) != 0 {
+		t.Errorf("server received %d messages in cleartext", len(messages))
+	}
+}
+
+func TestSendImplicitTLS(t *testing.T) {
+	server, pool := newFakeSMTP(t, true)
+
+	if err := server.client(pool, TLSImplicit, AuthNone).Send(context.Background(), []string{"ops@example.com"}, testBody); err != nil {
+		t.Fatal(err)
+	}
+	_, messages := server.received()
+	if len(messages) != 1 || !messages[0].tls {
+		t.Fatalf("messages = %+v, want one sent over TLS", messages)
+	}
+
+	// The certificate must be trusted
+	if err := server.client(nil, TLSImplicit, AuthNone).Check(context.Background()); err == nil {
+		t.Error("Check succeeded with an untrusted certificate")
+	}
+}
+
+func TestSendAuth(t *testing.T) {
+	tests := []struct {
+		auth    string
+		offered string
+		want    string
+	}{
+		{auth: AuthPlain, offered: "PLAIN LOGIN CRAM-MD5", want: "PLAIN"},
+		{auth: AuthLogin, offered: "PLAIN LOGIN CRAM-MD5", want: "LOGIN"},
+		{auth: AuthCRAMMD5, offered: "PLAIN LOGIN CRAM-MD5", want: "CRAM-MD5"},
+		{auth: AuthAuto, offered: "LOGIN PLAIN CRAM-MD5", want: "CRAM-MD5"},
+		{auth: AuthAuto, offered: "LOGIN PLAIN", want: "PLAIN"},
+		{auth: AuthAuto, offered: "LOGIN", want: "LOGIN"},
+	}
+	for _, tt := range tests {
+		server, pool := newFakeSMTP(t, false)
+		server.startTLS, server.auth = true, tt.offered
+
+		if err := server.client(pool, TLSStartTLSRequired, tt.auth).Send(context.Background(), []string{"ops@example.com"}, testBody); err != nil {
+			t.Errorf("%s with %q offered: %v", tt.auth, tt.offered, err)
+			continue
+		}
+		if _, messages := server.received(); len(messages) != 1 || messages[0].auth != tt.want {
+			t.Errorf("%s with %q offered: messages = %+v, want one authenticated with %s", tt.auth, tt.offered, messages, tt.want)
+		}
+	}
+}
+
+func TestSendAuthRejected(t *testing.T) {
+	server, pool := newFakeSMTP(t, false)
+	server.startTLS, server.auth = true, "PLAIN"
+
+	client := server.client(pool, TLSStartTLSRequired, AuthPlain)
+	client.Password = "wrong"
+	err := client.Send(context.Background(), []string{"ops@example.com"}, testBody)
+	if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Fatalf("Send = %v, want an authentication error", err)
+	}
+	if sessions, _ := server.received(); sessions != 1 {
+		t.Errorf("a 535 rejection was tried %d times, want 1", sessions)
+	}
+}
+
+// Retries are left to the notify phase, Send makes a single attempt
+func TestSendSingleAttempt(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies []string
+		err     string
+	}{
+		{name: "temporary failure", replies: []string{"451 try again later"}, err: "try again later"},
+		{name: "permanent failure", replies: []string{"550 sender rejected"}, err: "sender rejected"},
+	}
+	for _, tt := range tests {
+		server, pool := newFakeSMTP(t, false)
+		server.mailReplies = tt.replies
+
+		client := server.client(pool, TLSNone, AuthNone)
+		err := client.Send(context.Background(), []string{"ops@example.com"}, testBody)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: Send = %v, want an error containing %q", tt.name, err, tt.err)
+		}
+		if sessions, _ := server.received(); sessions != 1 {
+			t.Errorf("%s: %d sessions, want 1", tt.name, sessions)
+		}
+	}
+}
+
+// The notify phase retries temporary failures, 5xx rejections are permanent
+func TestSendRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		replies  []string
+		sessions int
+		err      bool
+	}{
+		{name: "temporary failure is retried", replies: []string{"451 try again later"}, sessions: 2},
+		{name: "retries are limited", replies: []string{"421 busy", "421 busy", "421 busy"}, sessions: 3, err: true},
+		{name: "rejected sender is not retried", replies: []string{"550 sender rejected"}, sessions: 1, err: true},
+	}
+	for _, tt := range tests {
+		server, pool := newFakeSMTP(t, false)
+		server.mailReplies = tt.replies
+
+		client := server.client(pool, TLSNone, AuthNone)
+		policy := retry.Policy{Attempts: 3, Delay: time.Millisecond}
+		attempts, err := policy.Do(context.Background(), func(ctx context.Context) error {
+			return client.Send(ctx, []string{"ops@example.com"}, testBody)
+		}, nil)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: Send = %v", tt.name, err)
+		}
+		if sessions, _ := server.received(); sessions != tt.sessions || attempts != tt.sessions {
+			t.Errorf("%s: %d sessions and %d attempts, want %d", tt.name, sessions, attempts, tt.sessions)
+		}
+	}
+}
+
+func TestSendCanceled(t *testing.T) {
+	server, pool := newFakeSMTP(t, false)
+	client := server.client(pool, TLSNone, AuthNone)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.Send(ctx, []string{"ops@example.com"}, testBody); err == nil {
+		t.Fatal("Send with a canceled context succeeded")
+	}
+	if _, messages := server.received(); len(messages) != 0 {
+		t.Errorf("%d messages delivered with a canceled context", len(messages))
+	}
+}
+
+func TestBccOnlyInEnvelope(t *testing.T) {
+	server, pool := newFakeSMTP(t, false)
+	client := server.client(pool, TLSNone, AuthNone)
+
+	recipients := Recipients{
+		To:  []string{"to@example.com"},
+		Cc:  []string{"cc@example.com"},
+		Bcc: []string{"hidden@example.com"},
+	}
+	if err := sendMultipartEmail(context.Background(), client, recipients, "subject", "text", "<p>html</p>"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, messages := server.received()
+	if len(messages) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(messages))
+	}
+	msg := messages[0]
+	if fmt.Sprint(msg.rcpt) != fmt.Sprint(recipients.All()) {
+		t.Errorf("envelope recipients = %v, want %v", msg.rcpt, recipients.All())
+	}
+	if msg.from != testUser {
+		t.Errorf("envelope sender = %q, want %q", msg.from, testUser)
+	}
+	headers, _, _ := strings.Cut(msg.data, "\r\n\r\n")
+	if strings.Contains(msg.data, "hidden@example.com") || strings.Contains(strings.ToLower(headers), "bcc:") {
+		t.Errorf("Bcc recipient appears in the message:\n%s", headers)
+	}
+	for _, addr := range []string{"to@example.com", "cc@example.com"} {
+		if !strings.Contains(headers, addr) {
+			t.Errorf("header is missing %s:\n%s", addr, headers)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package email
+
+import (
+	"PostgresDump/pkg/notify"
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	v "github.com/spf13/viper"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Templates and locale catalogs are built into the binary. Files in
+// email_template_dir take precedence: <dir>/<name>.html, <dir>/<name>.txt and
+// <dir>/locales/<lang>.json.
+//
+//go:embed template locales
+var assets embed.FS
+
+// fallbackLang provides the strings missing from other locale catalogs
+const fallbackLang = "en"
+
+// digestTemplate is the template and subject key of digest emails
+const digestTemplate = "digest"
+
+var colors = map[string]string{
+	string(notify.EventSuccess):      "#4CAF50",
+	string(notify.EventFailure):      "#E53935",
+	string(notify.EventWarning):      "#FB8C00",
+	string(notify.EventCleanupError): "#8E24AA",
+	digestTemplate:                   "#2196F3",
+}
+
+// locale maps translation keys to strings in one language
+type locale map[string]string
+
+// t returns the translation of key, formatted with args when given
+func (l locale) t(key string, args ...any) string {
+	format, ok := l[key]
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Validate checks that the configured language, templates and subjects can be used
+func Validate() error {
+	l, err := loadLocale(emailLang())
+	if err != nil {
+		return err
+	}
+
+	names := []string{digestTemplate}
+	for _, event := range notify.Events {
+		names = append(names, string(event))
+	}
+	for _, name := range names {
+		if _, err := parseHTML(l, name); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{"report", digestTemplate} {
+		if _, err := parseText(l, name); err != nil {
+			return err
+		}
+	}
+
+	for key := range v.GetStringMapString("email_subjects") {
+		if key != digestTemplate && !notify.Event(key).IsKnown() {
+			return fmt.Errorf("unknown event %q in email_subjects, expected one of %v or %s", key, notify.Events, digestTemplate)
+		}
+	}
+	return nil
+}
+
+func emailLang() string {
+	if lang := strings.ToLower(v.GetString("email_lang")); lang != "" {
+		return lang
+	}
+	return fallbackLang
+}
+
+// loadLocale merges the fallback catalog with the catalog of lang, built-in
+// catalogs first and overrides on top
+func loadLocale(lang string) (locale, error) {
+	l := make(locale)
+	found := false
+	for _, name := range []string{fallbackLang, lang} {
+		for _, read := range []func(string) ([]byte, error){assets.ReadFile, readOverride} {
+			data, err := read("locales/" + name + ".json")
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(data, &l); err != nil {
+				return nil, fmt.Errorf("failed to parse locale %s: %w", name, err)
+			}
+			if name == lang {
+				found = true
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no locale catalog for email_lang %q", lang)
+	}
+	return l, nil
+}
+
+// readAsset returns a template, preferring the override directory
+func readAsset(name string) ([]byte, error) {
+	data, err := readOverride(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return assets.ReadFile("template/" + name)
+	}
+	return data, err
+}
+
+func readOverride(name string) ([]byte, error) {
+	dir := v.GetString("email_template_dir")
+	if dir == "" {
+		return nil, fs.ErrNotExist
+	}
+	return os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+}
+
+func parseHTML(l locale, name string) (*htmltemplate.Template, error) {
+	tmpl := htmltemplate.New(name).Funcs(htmltemplate.FuncMap{"t": l.t})
+	for _, file := range []string{"layout.html", name + ".html"} {
+		data, err := readAsset(file)
+		if err != nil {
+			return nil, fmt.Errorf("error loading template %s: %w", file, err)
+		}
+		if _, err := tmpl.Parse(string(data)); err != nil {
+			return nil, fmt.Errorf("error parsing template %s: %w", file, err)
+		}
+	}
+	return tmpl, nil
+}
+
+func parseText(l locale, name string) (*texttemplate.Template, error) {
+	data, err := readAsset(name + ".txt")
+	if err != nil {
+		return nil, fmt.Errorf("error loading template %s.txt: %w", name, err)
+	}
+	tmpl, err := texttemplate.New(name).Funcs(texttemplate.FuncMap{"t": l.t}).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template %s.txt: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// render executes the HTML page and the plain text template with the same data
+func render(l locale, page, text string, data any) (htmlBody, textBody string, err error) {
+	htmlTmpl, err := parseHTML(l, page)
+	if err != nil {
+		return "", "", err
+	}
+	var html bytes.Buffer
+	if err := htmlTmpl.ExecuteTemplate(&html, "layout", data); err != nil {
+		return "", "", fmt.Errorf("error processing template %s.html: %w", page, err)
+	}
+
+	textTmpl, err := parseText(l, text)
+	if err != nil {
+		return "", "", err
+	}
+	var plain bytes.Buffer
+	if err := textTmpl.Execute(&plain, data); err != nil {
+		return "", "", fmt.Errorf("error processing template %s.txt: %w", text, err)
+	}
+	return html.String(), strings.TrimSpace(plain.String()) + "\n", nil
+}
+
+// subject builds the subject from email_subjects or the locale catalog.
+// Placeholders: {job}, {db}, {host}, {date}, {period}.
+func subject(l locale, key, catalogKey string, vars map[string]string) string {
+	format := v.GetStringMapString("email_subjects")[key]
+	if format == "" {
+		format = l.t(catalogKey)
+	}
+	var pairs []string
+	for name, value := range vars {
+		pairs = append(pairs, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(format)
+}
@@ -0,0 +1,290 @@
+package keytpl
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TimestampLayout is the layout used for the {timestamp} placeholder
+const TimestampLayout = "2006-01-02_15-04-05"
+
+// DefaultTemplate is used when no key template is configured
+const DefaultTemplate = "{cluster}/{db}/{yyyy}/{mm}/{db}_{timestamp}.dump"
+
+// DefaultPhysicalTemplate is used for base backups when no key template is configured
+const DefaultPhysicalTemplate = "{cluster}/{db}/{yyyy}/{mm}/{db}_{timestamp}_base.tar"
+
+// Vars holds the values substituted into a key template
+type Vars struct {
+	Job      string
+	Cluster  string
+	Host     string
+	Database string
+	Time     time.Time
+}
+
+// Template is a validated object key template, e.g. "{cluster}/{db}/{yyyy}/{db}_{timestamp}.dump"
+type Template struct {
+	raw   string
+	parts []part
+	re    *regexp.Regexp
+}
+
+type part struct {
+	literal     string
+	placeholder string
+}
+
+// placeholders lists every supported placeholder and whether it depends on the backup time
+var placeholders = map[string]bool{
+	"job":       false,
+	"cluster":   false,
+	"host":      false,
+	"db":        false,
+	"yyyy":      true,
+	"mm":        true,
+	"dd":        true,
+	"timestamp": true,
+}
+
+var placeholderPatterns = map[string]string{
+	"yyyy":      `\d{4}`,
+	"mm":        `\d{2}`,
+	"dd":        `\d{2}`,
+	"timestamp": `\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2}`,
+}
+
+// Parse validates a key template and prepares it for rendering and matching
+func Parse(raw string) (*Template, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, fmt.Errorf("key template is empty")
+	}
+	if strings.HasPrefix(raw, "/") {
+		return nil, fmt.Errorf("key template %q must not start with '/'", raw)
+	}
+	if strings.HasSuffix(raw, "/") {
+		return nil, fmt.Errorf("key template %q must not end with '/'", raw)
+	}
+	for _, segment := range strings.Split(raw, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return nil, fmt.Errorf("key template %q contains an invalid path segment %q", raw, segment)
+		}
+	}
+
+	t := &Template{raw: raw}
+	rest := raw
+	hasTimestamp := false
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		closing := strings.IndexByte(rest, '}')
+		if open < 0 {
+			if closing >= 0 {
+				return nil, fmt.Errorf("key template %q has an unmatched '}'", raw)
+			}
+			t.parts = append(t.parts, part{literal: rest})
+			break
+		}
+		if closing >= 0 && closing < open {
+			return nil, fmt.Errorf("key template %q has an unmatched '}'", raw)
+		}
+		if open > 0 {
+			t.parts = append(t.parts, part{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("key template %q has an unclosed '{'", raw)
+		}
+		name := rest[open+1 : open+end]
+		if _, ok := placeholders[name]; !ok {
+			return nil, fmt.Errorf("key template %q uses unknown placeholder {%s}", raw, name)
+		}
+		if name == "timestamp" {
+			hasTimestamp = true
+		}
+		t.parts = append(t.parts, part{placeholder: name})
+		rest = rest[open+end+1:]
+	}
+
+	if !hasTimestamp {
+		return nil, fmt.Errorf("key template %q must contain {timestamp} so that keys are unique and sortable", raw)
+	}
+	if err := t.compile(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// String returns the template as configured
+func (t *Template) String() string {
+	return t.raw
+}
+
+// Render builds the object key for the given values
+func (t *Template) Render(v Vars) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.placeholder == "" {
+			b.WriteString(p.literal)
+			continue
+		}
+		b.WriteString(value(p.placeholder, v))
+	}
+	return b.String()
+}
+
+// Prefix returns the part of the key that doesn't depend on the backup time.
+// It is used to narrow listings to the keys of a single database.
+func (t *Template) Prefix(v Vars) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.placeholder == "" {
+			b.WriteString(p.literal)
+			continue
+		}
+		if placeholders[p.placeholder] {
+			break
+		}
+		b.WriteString(value(p.placeholder, v))
+	}
+	return b.String()
+}
+
+// Match reports whether key was produced by this template for the given values
+// and returns the backup time encoded in it
+func (t *Template) Match(key string, v Vars) (time.Time, bool) {
+	parsed, ok := t.Parse(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	v.Time = parsed.Time
+	if t.Render(v) != key {
+		return time.Time{}, false
+	}
+	return parsed.Time, true
+}
+
+// Parse extracts the placeholder values from a key produced by this template
+func (t *Template) Parse(key string) (Vars, bool) {
+	m := t.re.FindStringSubmatch(key)
+	if m == nil {
+		return Vars{}, false
+	}
+
+	group := func(name string) string {
+		if i := t.re.SubexpIndex(name); i >= 0 {
+			return m[i]
+		}
+		return ""
+	}
+	parsed, err := time.ParseInLocation(TimestampLayout, group("timestamp"), time.Local)
+	if err != nil {
+		return Vars{}, false
+	}
+	v := Vars{
+		Job:      group("job"),
+		Cluster:  group("cluster"),
+		Host:     group("host"),
+		Database: group("db"),
+		Time:     parsed,
+	}
+
+	// A placeholder used twice must have the same value in both places
+	if t.Render(v) != key {
+		return Vars{}, false
+	}
+	// Keys written by someone else must not lead out of the backup directory
+	if t.Check(v) != nil {
+		return Vars{}, false
+	}
+	return v, true
+}
+
+// Check reports an error when a value substituted by the template would add
+// or remove path segments, so that Match couldn't find the key again or the
+// local copy would be stored outside the backup directory
+func (t *Template) Check(v Vars) error {
+	for _, p := range t.parts {
+		if p.placeholder == "" || placeholders[p.placeholder] {
+			continue
+		}
+		if err := CheckValue(value(p.placeholder, v)); err != nil {
+			return fmt.Errorf("{%s}: %w", p.placeholder, err)
+		}
+	}
+	return nil
+}
+
+// CheckValue reports an error when value can't be substituted into a key:
+// it is empty, contains a path separator or a control character, or is a
+// relative path segment
+func CheckValue(value string) error {
+	switch {
+	case value == "":
+		return fmt.Errorf("value is empty")
+	case value == "." || value == "..":
+		return fmt.Errorf("value %q is a relative path segment", value)
+	case strings.ContainsAny(value, `/\`):
+		return fmt.Errorf("value %q must not contain '/' or '\\'", value)
+	}
+	for _, r := range value {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("value %q must not contain control characters", value)
+		}
+	}
+	return nil
+}
+
+func (t *Template) compile() error {
+	var b strings.Builder
+	b.WriteString("^")
+	seen := make(map[string]bool)
+	for _, p := range t.parts {
+		if p.placeholder == "" {
+			b.WriteString(regexp.QuoteMeta(p.literal))
+			continue
+		}
+		pattern, ok := placeholderPatterns[p.placeholder]
+		if !ok {
+			pattern = `[^/]+?`
+		}
+		if seen[p.placeholder] {
+			b.WriteString(pattern)
+			continue
+		}
+		seen[p.placeholder] = true
+		b.WriteString(fmt.Sprintf("(?P<%s>%s)", p.placeholder, pattern))
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return fmt.Errorf("key template %q can't be matched: %w", t.raw, err)
+	}
+	t.re = re
+	return nil
+}
+
+func value(name string, v Vars) string {
+	switch name {
+	case "job":
+		return v.Job
+	case "cluster":
+		return v.Cluster
+	case "host":
+		return v.Host
+	case "db":
+		return v.Database
+	case "yyyy":
+		return v.Time.Format("2006")
+	case "mm":
+		return v.Time.Format("01")
+	case "dd":
+		return v.Time.Format("02")
+	case "timestamp":
+		return v.Time.Format(TimestampLayout)
+	}
+	return ""
+}
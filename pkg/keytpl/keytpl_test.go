@@ -0,0 +1,182 @@
+package keytpl
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2024, 3, 7, 4, 5, 6, 0, time.Local)
+
+var testVars = Vars{
+	Job:      "billing",
+	Cluster:  "main",
+	Host:     "db1.example.com",
+	Database: "billing",
+	Time:     testTime,
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw string
+		err string
+	}{
+		{raw: DefaultTemplate},
+		{raw: DefaultPhysicalTemplate},
+		{raw: "backups/{job}-{timestamp}.dump"},
+		{raw: "", err: "is empty"},
+		{raw: "/{timestamp}", err: "must not start with '/'"},
+		{raw: "{timestamp}/", err: "must not end with '/'"},
+		{raw: "a//{timestamp}", err: "invalid path segment"},
+		{raw: "../{timestamp}", err: "invalid path segment"},
+		{raw: "{db}.dump", err: "must contain {timestamp}"},
+		{raw: "{db/{timestamp}", err: "unknown placeholder"},
+		{raw: "{db}}/{timestamp}", err: "unmatched '}'"},
+		{raw: "{timestamp}{db", err: "unclosed '{'"},
+		{raw: "{schema}/{timestamp}", err: "unknown placeholder {schema}"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.raw)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("Parse(%q) = %v, want no error", tt.raw, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("Parse(%q) = %v, want an error containing %q", tt.raw, err, tt.err)
+		}
+	}
+}
+
+func TestRenderAndPrefix(t *testing.T) {
+	tests := []struct {
+		raw    string
+		key    string
+		prefix string
+	}{
+		{
+			raw:    DefaultTemplate,
+			key:    "main/billing/2024/03/billing_2024-03-07_04-05-06.dump",
+			prefix: "main/billing/",
+		},
+		{
+			raw:    "pg/{host}/{job}/{yyyy}-{mm}-{dd}/{timestamp}.dump",
+			key:    "pg/db1.example.com/billing/2024-03-07/2024-03-07_04-05-06.dump",
+			prefix: "pg/db1.example.com/billing/",
+		},
+		{
+			raw:    "{timestamp}_{db}.dump",
+			key:    "2024-03-07_04-05-06_billing.dump",
+			prefix: "",
+		},
+	}
+	for _, tt := range tests {
+		tpl, err := Parse(tt.raw)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.raw, err)
+		}
+		if got := tpl.Render(testVars); got != tt.key {
+			t.Errorf("%q: Render = %q, want %q", tt.raw, got, tt.key)
+		}
+		if got := tpl.Prefix(testVars); got != tt.prefix {
+			t.Errorf("%q: Prefix = %q, want %q", tt.raw, got, tt.prefix)
+		}
+		if !strings.HasPrefix(tt.key, tpl.Prefix(testVars)) {
+			t.Errorf("%q: key %q doesn't start with its prefix", tt.raw, tt.key)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tpl, err := Parse(DefaultTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := testVars
+	other.Database = "crm"
+
+	tests := []struct {
+		name string
+		key  string
+		vars Vars
+		ok   bool
+	}{
+		{name: "own key", key: "main/billing/2024/03/billing_2024-03-07_04-05-06.dump", vars: testVars, ok: true},
+		{name: "other database", key: "main/billing/2024/03/billing_2024-03-07_04-05-06.dump", vars: other},
+		{name: "other extension", key: "main/billing/2024/03/billing_2024-03-07_04-05-06.dump.sha256", vars: testVars},
+		{name: "month differs from timestamp", key: "main/billing/2024/04/billing_2024-03-07_04-05-06.dump", vars: testVars},
+		{name: "database differs in file name", key: "main/billing/2024/03/crm_2024-03-07_04-05-06.dump", vars: testVars},
+		{name: "extra segment", key: "main/billing/x/2024/03/billing_2024-03-07_04-05-06.dump", vars: testVars},
+		{name: "invalid timestamp", key: "main/billing/2024/13/billing_2024-13-07_04-05-06.dump", vars: testVars},
+	}
+	for _, tt := range tests {
+		created, ok := tpl.Match(tt.key, tt.vars)
+		if ok != tt.ok {
+			t.Errorf("%s: Match(%q) = %v, want %v", tt.name, tt.key, ok, tt.ok)
+			continue
+		}
+		if ok && !created.Equal(testTime) {
+			t.Errorf("%s: Match(%q) time = %v, want %v", tt.name, tt.key, created, testTime)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tpl, err := Parse("{cluster}/{db}/{db}_{timestamp}.dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, ok := tpl.Parse("main/billing/billing_2024-03-07_04-05-06.dump")
+	if !ok {
+		t.Fatal("Parse didn't match a rendered key")
+	}
+	if v.Cluster != "main" || v.Database != "billing" || !v.Time.Equal(testTime) {
+		t.Errorf("Parse = %+v", v)
+	}
+
+	for _, key := range []string{
+		"main/billing/crm_2024-03-07_04-05-06.dump",
+		"main/../../billing_2024-03-07_04-05-06.dump",
+		"../billing/billing_2024-03-07_04-05-06.dump",
+	} {
+		if v, ok := tpl.Parse(key); ok {
+			t.Errorf("Parse(%q) = %+v, want no match", key, v)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tpl, err := Parse(DefaultTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tpl.Check(testVars); err != nil {
+		t.Errorf("Check = %v, want no error", err)
+	}
+
+	tests := []struct {
+		cluster string
+		err     string
+	}{
+		{cluster: "", err: "empty"},
+		{cluster: ".", err: "relative path segment"},
+		{cluster: "..", err: "relative path segment"},
+		{cluster: "/var/run/postgresql", err: "must not contain"},
+		{cluster: `a\b`, err: "must not contain"},
+		{cluster: "a\nb", err: "control characters"},
+	}
+	for _, tt := range tests {
+		v := testVars
+		v.Cluster = tt.cluster
+		err := tpl.Check(v)
+		if err == nil || !strings.Contains(err.Error(), tt.err) || !strings.HasPrefix(err.Error(), "{cluster}") {
+			t.Errorf("Check(cluster %q) = %v, want an error about {cluster} containing %q", tt.cluster, err, tt.err)
+		}
+	}
+
+	// Only the placeholders used by the template are checked
+	v := testVars
+	v.Host = "/var/run/postgresql"
+	if err := tpl.Check(v); err != nil {
+		t.Errorf("Check with an unused host = %v, want no error", err)
+	}
+}
@@ -0,0 +1,146 @@
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Lock is held by at most one instance at a time
+type Lock interface {
+	// Acquire takes the lock or confirms that it is still held, it returns
+	// false when another instance holds it
+	Acquire(ctx context.Context) (bool, error)
+	// Release gives the lock up so that another instance can take it over
+	Release(ctx context.Context) error
+}
+
+// Elector keeps trying to hold a set of named locks. The instance holding a
+// lock leads its name, when it dies or loses the lock another instance takes
+// over on its next attempt.
+type Elector struct {
+	// ID identifies this instance in logs and lease objects
+	ID       string
+	Log      *slog.Logger
+	Interval time.Duration
+
+	mu      sync.Mutex
+	names   []string
+	locks   map[string]Lock
+	leading map[string]bool
+	errs    map[string]string
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// New creates an elector that renews its locks every interval
+func New(id string, log *slog.Logger, interval time.Duration) *Elector {
+	return &Elector{
+		ID:       id,
+		Log:      log,
+		Interval: interval,
+		locks:    make(map[string]Lock),
+		leading:  make(map[string]bool),
+		errs:     make(map[string]string),
+	}
+}
+
+// Add registers the lock of a name, it must be called before Start
+func (e *Elector) Add(name string, lock Lock) {
+	e.names = append(e.names, name)
+	e.locks[name] = lock
+}
+
+// Leads reports whether this instance currently holds the lock of the name
+func (e *Elector) Leads(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading[name]
+}
+
+// Names returns the registered names in the order they were added
+func (e *Elector) Names() []string {
+	return e.names
+}
+
+// Start tries to acquire every lock once and then keeps renewing them in the
+// background until Stop
+func (e *Elector) Start(ctx context.Context) {
+	ctx, e.stop = context.WithCancel(ctx)
+	e.done = make(chan struct{})
+	e.campaign(ctx)
+	go func() {
+		defer close(e.done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(e.Interval):
+				e.campaign(ctx)
+			}
+		}
+	}()
+}
+
+// Stop stops renewing and releases the held locks
+func (e *Elector) Stop() {
+	if e.stop == nil {
+		return
+	}
+	e.stop()
+	<-e.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.Interval)
+	defer cancel()
+	for _, name := range e.names {
+		if !e.Leads(name) {
+			continue
+		}
+		if err := e.locks[name].Release(ctx); err != nil {
+			e.Log.Warn("⚠️ Error releasing leadership", "name", name, "error", err)
+			continue
+		}
+		e.set(name, false)
+		e.Log.Info("👋 Released leadership", "name", name, "instance", e.ID)
+	}
+}
+
+// campaign acquires or renews every lock, each attempt is bounded by Interval
+func (e *Elector) campaign(ctx context.Context) {
+	for _, name := range e.names {
+		attemptCtx, cancel := context.WithTimeout(ctx, e.Interval)
+		held, err := e.locks[name].Acquire(attemptCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		e.mu.Lock()
+		was := e.leading[name]
+		prevErr := e.errs[name]
+		e.errs[name] = ""
+		if err != nil {
+			e.errs[name] = err.Error()
+		}
+		e.mu.Unlock()
+
+		if err != nil && err.Error() != prevErr {
+			e.Log.Warn("⚠️ Leader election failed", "name", name, "error", err)
+		}
+		e.set(name, held)
+		switch {
+		case held && !was:
+			e.Log.Info("👑 Became leader", "name", name, "instance", e.ID)
+		case !held && was:
+			e.Log.Warn("👥 Lost leadership", "name", name, "instance", e.ID)
+		}
+	}
+}
+
+func (e *Elector) set(name string, held bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leading[name] = held
+}
@@ -0,0 +1,80 @@
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+
+	_ "github.com/lib/pq"
+)
+
+// PostgresLock is a session-level advisory lock. The server releases it when
+// the session ends, so a crashed holder loses it as soon as its connection is
+// gone.
+type PostgresLock struct {
+	dsn  string
+	key  int64
+	db   *sql.DB
+	conn *sql.Conn
+}
+
+// NewPostgresLock creates an advisory lock named name on the server of dsn
+func NewPostgresLock(dsn, name string) *PostgresLock {
+	return &PostgresLock{dsn: dsn, key: LockKey(name)}
+}
+
+// LockKey derives the advisory lock key of a name
+func LockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("pgsnapsafe:" + name))
+	return int64(h.Sum64())
+}
+
+// Acquire keeps the session that holds the lock open, a session that stopped
+// responding is dropped and the lock is tried again
+func (l *PostgresLock) Acquire(ctx context.Context) (bool, error) {
+	if l.conn != nil {
+		if _, err := l.conn.ExecContext(ctx, "SELECT 1"); err == nil {
+			return true, nil
+		}
+		l.conn.Close()
+		l.conn = nil
+	}
+
+	if l.db == nil {
+		db, err := sql.Open("postgres", l.dsn)
+		if err != nil {
+			return false, err
+		}
+		// Closing a connection has to end its session, a pooled one would keep the lock
+		db.SetMaxIdleConns(0)
+		l.db = db
+	}
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("PostgreSQL is unavailable: %w", err)
+	}
+	var held bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&held); err != nil {
+		conn.Close()
+		return false, fmt.Errorf("failed to take advisory lock: %w", err)
+	}
+	if !held {
+		conn.Close()
+		return false, nil
+	}
+	l.conn = conn
+	return true, nil
+}
+
+// Release ends the session that holds the lock
+func (l *PostgresLock) Release(ctx context.Context) error {
+	if l.conn == nil {
+		return nil
+	}
+	_, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key)
+	l.conn.Close()
+	l.conn = nil
+	return err
+}
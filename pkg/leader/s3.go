@@ -0,0 +1,127 @@
+package leader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// lease is the content of a lease object
+type lease struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// S3Lock is a lease object that expires unless its holder renews it within
+// the TTL. Conditional writes make sure that only one instance creates or
+// takes over the lease, the bucket has to support If-Match and If-None-Match
+// on PutObject.
+type S3Lock struct {
+	client *s3.Client
+	bucket string
+	key    string
+	holder string
+	ttl    time.Duration
+	// etag is the version of the lease object written by this instance
+	etag string
+}
+
+// NewS3Lock creates a lease stored under key that holder keeps for ttl after every renewal
+func NewS3Lock(client *s3.Client, bucket, key, holder string, ttl time.Duration) *S3Lock {
+	return &S3Lock{client: client, bucket: bucket, key: key, holder: holder, ttl: ttl}
+}
+
+// Acquire creates the lease, renews it or takes over an expired one
+func (l *S3Lock) Acquire(ctx context.Context) (bool, error) {
+	current, etag, err := l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	if current != nil && current.Holder != l.holder && time.Now().Before(current.ExpiresAt) {
+		l.etag = ""
+		return false, nil
+	}
+	return l.write(ctx, etag, time.Now().Add(l.ttl))
+}
+
+// Release expires the lease if this instance still holds it
+func (l *S3Lock) Release(ctx context.Context) error {
+	if l.etag == "" {
+		return nil
+	}
+	_, err := l.write(ctx, l.etag, time.Now())
+	l.etag = ""
+	return err
+}
+
+// read returns the current lease and its ETag, nil when there is none
+func (l *S3Lock) read(ctx context.Context) (*lease, string, error) {
+	out, err := l.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(l.bucket),
+		Key:    aws.String(l.key),
+	})
+	var noKey *types.NoSuchKey
+	if errors.As(err, &noKey) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read lease %s: %w", l.key, err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read lease %s: %w", l.key, err)
+	}
+	var current lease
+	if err := json.Unmarshal(data, &current); err != nil {
+		// A damaged lease is replaced like an expired one
+		return &lease{}, aws.ToString(out.ETag), nil
+	}
+	return &current, aws.ToString(out.ETag), nil
+}
+
+// write stores a lease held by this instance until expires. It replaces the
+// version etag, or creates the object when etag is empty, and returns false
+// when another instance wrote the lease first.
+func (l *S3Lock) write(ctx context.Context, etag string, expires time.Time) (bool, error) {
+	data, err := json.Marshal(lease{Holder: l.holder, ExpiresAt: expires.UTC()})
+	if err != nil {
+		return false, err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(l.bucket),
+		Key:         aws.String(l.key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}
+	if etag == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(etag)
+	}
+
+	out, err := l.client.PutObject(ctx, input)
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.ErrorCode() {
+			case "PreconditionFailed", "ConditionalRequestConflict":
+				l.etag = ""
+				return false, nil
+			}
+		}
+		return false, fmt.Errorf("failed to write lease %s: %w", l.key, err)
+	}
+	l.etag = aws.ToString(out.ETag)
+	return true, nil
+}
@@ -0,0 +1,108 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Digest periods
+const (
+	PeriodDaily  = "daily"
+	PeriodWeekly = "weekly"
+)
+
+// Digest summarizes every run of a period in one report
+type Digest struct {
+	Name   string      `json:"name"`
+	Period string      `json:"period"`
+	From   time.Time   `json:"from"`
+	To     time.Time   `json:"to"`
+	Jobs   []JobDigest `json:"jobs"`
+}
+
+// JobDigest holds the statistics of one job over the digest period
+type JobDigest struct {
+	Job       string `json:"job"`
+	Host      string `json:"host"`
+	Database  string `json:"database"`
+	Runs      int    `json:"runs"`
+	Failures  int    `json:"failures"`
+	Warnings  int    `json:"warnings"`
+	LastError string `json:"last_error,omitempty"`
+	// NewestBackup is zero when the job has no stored backups
+	NewestBackup time.Time     `json:"newest_backup"`
+	NewestAge    time.Duration `json:"newest_age"`
+	Backups      int           `json:"backups"`
+	TotalSize    int64         `json:"total_size"`
+	// SizeTrend is the change in percent of the average backup size against the
+	// previous period, valid only when HasTrend is set
+	SizeTrend      float64 `json:"size_trend"`
+	HasTrend       bool    `json:"has_trend"`
+	Deleted        int     `json:"deleted"`
+	Verified       int     `json:"verified"`
+	VerifyFailures int     `json:"verify_failures"`
+}
+
+// Healthy reports whether the job had no failed runs or verifications
+func (j JobDigest) Healthy() bool {
+	return j.Failures == 0 && j.VerifyFailures == 0 && !j.NewestBackup.IsZero()
+}
+
+// NewestAgeText returns the age of the newest backup, or "-" without backups
+func (j JobDigest) NewestAgeText() string {
+	if j.NewestBackup.IsZero() {
+		return "-"
+	}
+	return j.NewestAge.Round(time.Minute).String()
+}
+
+// TotalSizeText returns the stored size in human-readable units
+func (j JobDigest) TotalSizeText() string {
+	return FormatSize(j.TotalSize)
+}
+
+// SizeTrendText returns the size trend as a signed percentage, or "-" when unknown
+func (j JobDigest) SizeTrendText() string {
+	if !j.HasTrend {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", j.SizeTrend)
+}
+
+// DigestText renders the digest as plain text for chat channels
+func DigestText(d Digest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 Backup %s digest: %s – %s\n", d.Period,
+		d.From.Format("2006-01-02 15:04"), d.To.Format("2006-01-02 15:04"))
+	for _, j := range d.Jobs {
+		mark := "✅"
+		if !j.Healthy() {
+			mark = "❌"
+		}
+		fmt.Fprintf(&b, "\n%s %s (%s)\n", mark, j.Job, j.Database)
+		fmt.Fprintf(&b, "Runs: %d, failures: %d, warnings: %d\n", j.Runs, j.Failures, j.Warnings)
+		fmt.Fprintf(&b, "Newest backup age: %s\n", j.NewestAgeText())
+		fmt.Fprintf(&b, "Stored: %d backups, %s (trend %s)\n", j.Backups, j.TotalSizeText(), j.SizeTrendText())
+		fmt.Fprintf(&b, "Deleted by retention: %d\n", j.Deleted)
+		fmt.Fprintf(&b, "Verifications: %d, failed: %d\n", j.Verified, j.VerifyFailures)
+		if j.LastError != "" {
+			fmt.Fprintf(&b, "Last error: %s\n", j.LastError)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// FormatSize returns the size in human-readable binary units
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,195 @@
+package notify
+
+import (
+	"PostgresDump/pkg/retry"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Event is the kind of notification sent after a backup run
+type Event string
+
+const (
+	EventSuccess      Event = "success"
+	EventFailure      Event = "failure"
+	EventWarning      Event = "warning"
+	EventCleanupError Event = "cleanup_error"
+)
+
+// Events lists every supported event
+var Events = []Event{EventSuccess, EventFailure, EventWarning, EventCleanupError}
+
+// IsKnown reports whether the event is supported
+func (e Event) IsKnown() bool {
+	for _, known := range Events {
+		if e == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Report holds everything a notification can show about a backup run
+type Report struct {
+	Event    Event         `json:"event"`
+	Job      string        `json:"job"`
+	Host     string        `json:"host"`
+	Database string        `json:"database"`
+	FileName string        `json:"file_name,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Warnings []string      `json:"warnings,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Time     time.Time     `json:"time"`
+	// Backup details, set when a backup was created
+	Size          int64    `json:"size,omitempty"`
+	SHA256        string   `json:"sha256,omitempty"`
+	ServerVersion string   `json:"server_version,omitempty"`
+	Destinations  []string `json:"destinations,omitempty"`
+	// DownloadURL is a presigned S3 link valid until DownloadExpires. Anyone
+	// holding it can download the backup, so it is left out of the JSON and
+	// only channels configured for it show it.
+	DownloadURL     string    `json:"-"`
+	DownloadExpires time.Time `json:"-"`
+}
+
+// Notifier delivers reports and digests to a single channel
+type Notifier interface {
+	Notify(ctx context.Context, report Report) error
+	NotifyDigest(ctx context.Context, d Digest) error
+}
+
+// Route sends the listed events to the listed channels, a route without
+// events matches every event
+type Route struct {
+	Events   []Event  `mapstructure:"events"`
+	Channels []string `mapstructure:"channels"`
+}
+
+// Matches reports whether the route handles the event
+func (r Route) Matches(event Event) bool {
+	if len(r.Events) == 0 {
+		return true
+	}
+	for _, e := range r.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatcher sends reports to the channels selected by routes
+type Dispatcher struct {
+	Log      *slog.Logger
+	Channels map[string]Notifier
+	// Routes are used for jobs that don't define their own
+	Routes []Route
+}
+
+// Dispatch delivers the report through every matching route, retrying every
+// channel with the policy. A channel used by several matching routes receives
+// the report once. It returns the most attempts any channel needed.
+func (d *Dispatcher) Dispatch(ctx context.Context, routes []Route, report Report, policy retry.Policy) (int, error) {
+	if routes == nil {
+		routes = d.Routes
+	}
+
+	var errs []error
+	var attempts int
+	sent := make(map[string]bool)
+	for _, route := range routes {
+		if !route.Matches(report.Event) {
+			continue
+		}
+		for _, name := range route.Channels {
+			if sent[name] {
+				continue
+			}
+			sent[name] = true
+
+			channel, ok := d.Channels[name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("unknown notification channel %q", name))
+				continue
+			}
+			n, err := policy.Do(ctx, func(ctx context.Context) error {
+				return channel.Notify(ctx, report)
+			}, func(err error, wait time.Duration) {
+				d.Log.Warn("Error sending notification, retrying", "channel", name, "job", report.Job, "wait", wait.Round(time.Second).String(), "error", err)
+			})
+			attempts = max(attempts, n)
+			if err != nil {
+				d.Log.Error("Error sending notification", "channel", name, "job", report.Job, "event", report.Event, "error", err)
+				errs = append(errs, fmt.Errorf("channel %s: %w", name, err))
+			}
+		}
+	}
+	return attempts, errors.Join(errs...)
+}
+
+// SendDigest delivers the digest to the named channels
+func (d *Dispatcher) SendDigest(ctx context.Context, channels []string, digest Digest) error {
+	var errs []error
+	for _, name := range channels {
+		channel, ok := d.Channels[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown notification channel %q", name))
+			continue
+		}
+		if err := channel.NotifyDigest(ctx, digest); err != nil {
+			d.Log.Error("Error sending digest", "channel", name, "digest", digest.Name, "error", err)
+			errs = append(errs, fmt.Errorf("channel %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Title returns a short one-line summary of the report
+func Title(r Report) string {
+	switch r.Event {
+	case EventSuccess:
+		return fmt.Sprintf("✅ Backup created: %s", r.Job)
+	case EventFailure:
+		return fmt.Sprintf("❌ Backup failed: %s", r.Job)
+	case EventWarning:
+		return fmt.Sprintf("⚠️ Backup created with warnings: %s", r.Job)
+	case EventCleanupError:
+		return fmt.Sprintf("🚨 Backup cleanup failed: %s", r.Job)
+	}
+	return fmt.Sprintf("Backup %s: %s", r.Event, r.Job)
+}
+
+// Text renders the report as plain text for chat channels
+func Text(r Report) string {
+	var b strings.Builder
+	b.WriteString(Title(r))
+	b.WriteString("\n")
+	line := func(label, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", label, value)
+		}
+	}
+	line("Host", r.Host)
+	line("Database", r.Database)
+	line("File", r.FileName)
+	if r.Size > 0 {
+		line("Size", FormatSize(r.Size))
+	}
+	if r.Duration > 0 {
+		line("Duration", r.Duration.Round(time.Second).String())
+	}
+	line("PostgreSQL", r.ServerVersion)
+	for _, d := range r.Destinations {
+		line("Stored at", d)
+	}
+	line("Time", r.Time.Format("2006-01-02 15:04:05"))
+	for _, w := range r.Warnings {
+		line("Warning", w)
+	}
+	line("Error", r.Error)
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,183 @@
+package notify
+
+import (
+	"PostgresDump/pkg/retry"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// Webhook posts the report as JSON to an arbitrary URL
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	// DownloadLinks adds the presigned download link of the backup to the payload
+	DownloadLinks bool
+}
+
+func (w *Webhook) Notify(ctx context.Context, report Report) error {
+	payload := struct {
+		Report
+		Title           string     `json:"title"`
+		DurationSeconds float64    `json:"duration_seconds"`
+		DownloadURL     string     `json:"download_url,omitempty"`
+		DownloadExpires *time.Time `json:"download_expires,omitempty"`
+	}{
+		Report:          report,
+		Title:           Title(report),
+		DurationSeconds: report.Duration.Seconds(),
+	}
+	if w.DownloadLinks && report.DownloadURL != "" {
+		payload.DownloadURL, payload.DownloadExpires = report.DownloadURL, &report.DownloadExpires
+	}
+	return postJSON(ctx, w.URL, w.Headers, payload)
+}
+
+func (w *Webhook) NotifyDigest(ctx context.Context, d Digest) error {
+	payload := struct {
+		Event string `json:"event"`
+		Digest
+	}{
+		Event:  "digest",
+		Digest: d,
+	}
+	return postJSON(ctx, w.URL, w.Headers, payload)
+}
+
+// Slack posts the report to a Slack incoming webhook
+type Slack struct {
+	URL string
+}
+
+func (s *Slack) Notify(ctx context.Context, report Report) error {
+	return s.send(ctx, Text(report))
+}
+
+func (s *Slack) NotifyDigest(ctx context.Context, d Digest) error {
+	return s.send(ctx, DigestText(d))
+}
+
+func (s *Slack) send(ctx context.Context, text string) error {
+	return postJSON(ctx, s.URL, nil, map[string]any{
+		"text": text,
+	})
+}
+
+// Mattermost posts the report to a Mattermost incoming webhook
+type Mattermost struct {
+	URL string
+	// Channel and Username override the webhook defaults when set
+	Channel  string
+	Username string
+}
+
+func (m *Mattermost) Notify(ctx context.Context, report Report) error {
+	return m.send(ctx, Text(report))
+}
+
+func (m *Mattermost) NotifyDigest(ctx context.Context, d Digest) error {
+	return m.send(ctx, DigestText(d))
+}
+
+func (m *Mattermost) send(ctx context.Context, text string) error {
+	payload := map[string]any{
+		"text": text,
+	}
+	if m.Channel != "" {
+		payload["channel"] = m.Channel
+	}
+	if m.Username != "" {
+		payload["username"] = m.Username
+	}
+	return postJSON(ctx, m.URL, nil, payload)
+}
+
+// Telegram sends the report as a bot message to a chat
+type Telegram struct {
+	BotToken string
+	ChatID   string
+	// APIURL defaults to https://api.telegram.org
+	APIURL string
+}
+
+func (t *Telegram) Notify(ctx context.Context, report Report) error {
+	return t.send(ctx, Text(report))
+}
+
+func (t *Telegram) NotifyDigest(ctx context.Context, d Digest) error {
+	return t.send(ctx, DigestText(d))
+}
+
+func (t *Telegram) send(ctx context.Context, text string) error {
+	apiURL := t.APIURL
+	if apiURL == "" {
+		apiURL = "https://api.telegram.org"
+	}
+	endpoint := fmt.Sprintf("%s/bot%s/sendMessage", apiURL, t.BotToken)
+	return postJSON(ctx, endpoint, nil, map[string]any{
+		"chat_id":                  t.ChatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	})
+}
+
+func postJSON(ctx context.Context, endpoint string, headers map[string]string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create notification request: %w", withoutURL(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("notification endpoint returned %s: %s", resp.Status, bytes.TrimSpace(message))
+		if rejected(resp.StatusCode) {
+			return retry.Permanent(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// rejected reports whether the endpoint refused the request for good, a
+// client error other than a timeout or rate limit is repeated by every attempt
+func rejected(status int) bool {
+	return status >= 400 && status < 500 &&
+		status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+// withoutURL drops the request URL from an error of the HTTP client, webhook
+// URLs and the Telegram API path carry secrets that must not end up in logs
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	host := "notification endpoint"
+	if u, parseErr := url.Parse(urlErr.URL); parseErr == nil && u.Host != "" {
+		host = u.Host
+	}
+	return fmt.Errorf("%s %s: %w", urlErr.Op, host, urlErr.Err)
+}
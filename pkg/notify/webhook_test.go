@@ -0,0 +1,148 @@
+package notify
+
+import (
+	"PostgresDump/pkg/retry"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testToken = "123456:secret-bot-token"
+
+func TestTelegramErrorHidesToken(t *testing.T) {
+	// A closed listener refuses the connection
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiURL := "http://" + listener.Addr().String()
+	listener.Close()
+
+	tg := &Telegram{BotToken: testToken, ChatID: "1", APIURL: apiURL}
+	err = tg.send(context.Background(), "hello")
+	if err == nil {
+		t.Fatal("send to a closed port succeeded")
+	}
+	if strings.Contains(err.Error(), "secret-bot-token") {
+		t.Errorf("error contains the bot token: %v", err)
+	}
+	if !strings.Contains(err.Error(), listener.Addr().String()) {
+		t.Errorf("error doesn't name the host: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tg.send(ctx, "hello"); !errors.Is(err, context.Canceled) {
+		t.Errorf("send with a canceled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestTelegramInvalidURLHidesToken(t *testing.T) {
+	tg := &Telegram{BotToken: testToken, ChatID: "1", APIURL: "http://bad host"}
+	err := tg.send(context.Background(), "hello")
+	if err == nil || strings.Contains(err.Error(), "secret-bot-token") {
+		t.Errorf("send = %v, want an error without the bot token", err)
+	}
+}
+
+func TestTelegramSend(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	defer server.Close()
+
+	tg := &Telegram{BotToken: testToken, ChatID: "1", APIURL: server.URL}
+	if err := tg.send(context.Background(), "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/bot"+testToken+"/sendMessage" {
+		t.Errorf("request path = %q", path)
+	}
+}
+
+func TestWebhookDownloadLinks(t *testing.T) {
+	var payload map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload = nil
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	report := Report{
+		Event:           EventSuccess,
+		Job:             "billing",
+		DownloadURL:     "https://bucket.example.com/billing.dump?X-Amz-Signature=abc",
+		DownloadExpires: time.Now().Add(time.Hour),
+	}
+	tests := []struct {
+		downloadLinks bool
+		want          bool
+	}{
+		{downloadLinks: false, want: false},
+		{downloadLinks: true, want: true},
+	}
+	for _, tt := range tests {
+		w := &Webhook{URL: server.URL, DownloadLinks: tt.downloadLinks}
+		if err := w.Notify(context.Background(), report); err != nil {
+			t.Fatal(err)
+		}
+		_, hasURL := payload["download_url"]
+		_, hasExpires := payload["download_expires"]
+		if hasURL != tt.want || hasExpires != tt.want {
+			t.Errorf("download_links %v: payload has download_url %v and download_expires %v, want %v",
+				tt.downloadLinks, hasURL, hasExpires, tt.want)
+		}
+		if payload["job"] != "billing" {
+			t.Errorf("payload job = %v", payload["job"])
+		}
+	}
+}
+
+func TestDispatchRetries(t *testing.T) {
+	tests := []struct {
+		status   int
+		requests int32
+	}{
+		{status: http.StatusOK, requests: 1},
+		{status: http.StatusBadRequest, requests: 1},
+		{status: http.StatusNotFound, requests: 1},
+		{status: http.StatusTooManyRequests, requests: 3},
+		{status: http.StatusRequestTimeout, requests: 3},
+		{status: http.StatusBadGateway, requests: 3},
+	}
+	for _, tt := range tests {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(tt.status)
+		}))
+
+		d := &Dispatcher{
+			Log:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+			Channels: map[string]Notifier{"hook": &Webhook{URL: server.URL}},
+			Routes:   []Route{{Channels: []string{"hook"}}},
+		}
+		policy := retry.Policy{Attempts: 3, Delay: time.Millisecond}
+		attempts, err := d.Dispatch(context.Background(), nil, Report{Event: EventFailure, Job: "billing"}, policy)
+		server.Close()
+
+		if n := requests.Load(); n != tt.requests || int32(attempts) != tt.requests {
+			t.Errorf("status %d: %d requests and %d attempts, want %d", tt.status, n, attempts, tt.requests)
+		}
+		if (err != nil) != (tt.status != http.StatusOK) {
+			t.Errorf("status %d: Dispatch = %v", tt.status, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package pgbin
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultDirs are searched for versioned client programs before $PATH: the
+// Debian/Ubuntu layout and the PGDG RPM layout
+var DefaultDirs = []string{"/usr/lib/postgresql/*/bin", "/usr/pgsql-*/bin"}
+
+// Binary is an installed PostgreSQL client program
+type Binary struct {
+	Path string
+	// Version is the full version, such as 16.2
+	Version string
+	// Major is the major version, such as 16 (9 for 9.x releases)
+	Major int
+}
+
+var versionPattern = regexp.MustCompile(`\(PostgreSQL\) ((\d+)[.\d]*)`)
+
+// Find returns the installed versions of the program, one per major version,
+// newest first. dirs are glob patterns searched before $PATH.
+func Find(name string, dirs []string) ([]Binary, error) {
+	var candidates []string
+	for _, pattern := range dirs {
+		matches, err := filepath.Glob(filepath.Join(pattern, name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid directory pattern %q: %w", pattern, err)
+		}
+		candidates = append(candidates, matches...)
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		candidates = append(candidates, path)
+	}
+
+	seen := make(map[int]bool)
+	var binaries []Binary
+	for _, path := range candidates {
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		b, err := Version(path)
+		if err != nil || seen[b.Major] {
+			continue
+		}
+		seen[b.Major] = true
+		binaries = append(binaries, b)
+	}
+	if len(binaries) == 0 {
+		return nil, fmt.Errorf("%s not found in %s or $PATH", name, strings.Join(dirs, ", "))
+	}
+
+	sort.Slice(binaries, func(i, j int) bool { return binaries[i].Major > binaries[j].Major })
+	return binaries, nil
+}
+
+// Version runs the program with --version and parses its version
+func Version(path string) (Binary, error) {
+	output, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return Binary{}, fmt.Errorf("failed to run %s --version: %w", path, err)
+	}
+	match := versionPattern.FindSubmatch(output)
+	if match == nil {
+		return Binary{}, fmt.Errorf("unexpected version of %s: %q", path, strings.TrimSpace(string(output)))
+	}
+	major, _ := strconv.Atoi(string(match[2]))
+	return Binary{Path: path, Version: string(match[1]), Major: major}, nil
+}
+
+// ServerMajor converts server_version_num, such as 160002 or 90624, into a
+// major version comparable with Binary.Major
+func ServerMajor(versionNum int) int {
+	return versionNum / 10000
+}
+
+// Select returns the oldest binary that supports a server of the given major
+// version. Client programs work with servers up to their own version, and the
+// closest version keeps archives restorable with older pg_restore releases.
+func Select(binaries []Binary, serverMajor int) (Binary, error) {
+	var found []string
+	var best *Binary
+	for i := range binaries {
+		b := &binaries[i]
+		found = append(found, strconv.Itoa(b.Major))
+		if b.Major >= serverMajor && (best == nil || b.Major < best.Major) {
+			best = b
+		}
+	}
+	if best == nil {
+		return Binary{}, fmt.Errorf("no client for PostgreSQL %d installed (found %s), install the PostgreSQL %d client",
+			serverMajor, strings.Join(found, ", "), serverMajor)
+	}
+	return *best, nil
+}
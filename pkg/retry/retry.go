@@ -0,0 +1,143 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy controls how often and how long an operation is attempted
+type Policy struct {
+	// Timeout bounds each attempt, 0 means no limit
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Attempts is the total number of attempts, 1 when not set
+	Attempts int `mapstructure:"attempts"`
+	// Delay is the wait before the second attempt, it doubles after every
+	// attempt up to MaxDelay
+	Delay    time.Duration `mapstructure:"delay"`
+	MaxDelay time.Duration `mapstructure:"max_delay"`
+	// Jitter randomizes every wait by up to this fraction, 0.2 means ±20%
+	Jitter float64 `mapstructure:"jitter"`
+}
+
+// Validate checks that the values are usable
+func (p Policy) Validate() error {
+	switch {
+	case p.Timeout < 0, p.Delay < 0, p.MaxDelay < 0:
+		return errors.New("timeout, delay and max_delay must not be negative")
+	case p.Attempts < 0:
+		return errors.New("attempts must not be negative")
+	case p.Jitter < 0 || p.Jitter > 1:
+		return errors.New("jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// MaxDuration returns the longest Do can take with every attempt timing out,
+// 0 when attempts have no timeout
+func (p Policy) MaxDuration() time.Duration {
+	if p.Timeout <= 0 {
+		return 0
+	}
+	attempts := max(p.Attempts, 1)
+	total := time.Duration(attempts) * p.Timeout
+	delay := p.Delay
+	for i := 1; i < attempts; i++ {
+		total += time.Duration(float64(delay) * (1 + p.Jitter))
+		delay *= 2
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+	return total
+}
+
+// Error is returned when every attempt failed
+type Error struct {
+	Attempts int
+	Err      error
+}
+
+func (e *Error) Error() string {
+	if e.Attempts <= 1 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v (after %d attempts)", e.Err, e.Attempts)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// permanentError marks an error that is not worth retrying
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error so that Do returns it without retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// Do calls fn until it succeeds, returns a Permanent error, the attempts are
+// used up or ctx is done. Every attempt gets a context bounded by Timeout.
+// retrying, when not nil, is called before waiting for the next attempt.
+// It returns the number of attempts made, failures are returned as *Error.
+func (p Policy) Do(ctx context.Context, fn func(ctx context.Context) error, retrying func(err error, wait time.Duration)) (int, error) {
+	attempts := max(p.Attempts, 1)
+	delay := p.Delay
+
+	for attempt := 1; ; attempt++ {
+		err := p.attempt(ctx, fn)
+		if err == nil {
+			return attempt, nil
+		}
+		var perm permanentError
+		if errors.As(err, &perm) || attempt >= attempts || ctx.Err() != nil {
+			return attempt, &Error{Attempts: attempt, Err: err}
+		}
+
+		wait := p.jitter(delay)
+		if retrying != nil {
+			retrying(err, wait)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return attempt, &Error{Attempts: attempt, Err: err}
+		}
+		delay *= 2
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
+
+func (p Policy) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if p.Timeout <= 0 {
+		return fn(ctx)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	err := fn(attemptCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %w", p.Timeout, err)
+	}
+	return err
+}
+
+func (p Policy) jitter(d time.Duration) time.Duration {
+	if p.Jitter <= 0 || d <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + p.Jitter*(2*rand.Float64()-1)))
+}
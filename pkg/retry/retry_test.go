@@ -0,0 +1,209 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errFailed = errors.New("failed")
+
+func TestDoSucceeds(t *testing.T) {
+	calls := 0
+	p := Policy{Attempts: 3, Delay: time.Millisecond}
+	n, err := p.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls < 2 {
+			return errFailed
+		}
+		return nil
+	}, nil)
+	if err != nil || n != 2 {
+		t.Errorf("Do = %d, %v, want 2 attempts and no error", n, err)
+	}
+}
+
+func TestDoBackoff(t *testing.T) {
+	p := Policy{Attempts: 5, Delay: time.Millisecond, MaxDelay: 3 * time.Millisecond}
+	var waits []time.Duration
+	n, err := p.Do(context.Background(), func(ctx context.Context) error {
+		return errFailed
+	}, func(err error, wait time.Duration) {
+		waits = append(waits, wait)
+	})
+
+	if n != 5 {
+		t.Errorf("Do made %d attempts, want 5", n)
+	}
+	var retryErr *Error
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 5 || !errors.Is(err, errFailed) {
+		t.Errorf("Do = %v, want *Error wrapping the last failure after 5 attempts", err)
+	}
+	if !strings.Contains(err.Error(), "after 5 attempts") {
+		t.Errorf("error = %q, want the number of attempts", err)
+	}
+	// The delay doubles and is capped at MaxDelay
+	want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond, 3 * time.Millisecond}
+	if len(waits) != len(want) {
+		t.Fatalf("waits = %v, want %v", waits, want)
+	}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Errorf("waits = %v, want %v", waits, want)
+			break
+		}
+	}
+}
+
+func TestDoSingleAttempt(t *testing.T) {
+	n, err := Policy{}.Do(context.Background(), func(ctx context.Context) error {
+		return errFailed
+	}, nil)
+	if n != 1 || err == nil || err.Error() != "failed" {
+		t.Errorf("Do = %d, %v, want one attempt and the plain error", n, err)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	p := Policy{Jitter: 0.2}
+	d := 100 * time.Millisecond
+	lower, upper := 80*time.Millisecond, 120*time.Millisecond
+	varied := false
+	for i := 0; i < 1000; i++ {
+		got := p.jitter(d)
+		if got < lower || got > upper {
+			t.Fatalf("jitter(%s) = %s, want between %s and %s", d, got, lower, upper)
+		}
+		if got != d {
+			varied = true
+		}
+	}
+	if !varied {
+		t.Error("jitter never changed the delay")
+	}
+	if got := (Policy{}).jitter(d); got != d {
+		t.Errorf("jitter without Jitter = %s, want %s", got, d)
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	if Permanent(nil) != nil {
+		t.Error("Permanent(nil) != nil")
+	}
+
+	calls := 0
+	p := Policy{Attempts: 3, Delay: time.Millisecond}
+	n, err := p.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return Permanent(errFailed)
+	}, nil)
+	if n != 1 || calls != 1 {
+		t.Errorf("Do retried a permanent error: %d attempts", calls)
+	}
+	if !errors.Is(err, errFailed) {
+		t.Errorf("Do = %v, want it to wrap the permanent error", err)
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	p := Policy{Timeout: 10 * time.Millisecond, Attempts: 2}
+	var ctxErrs []error
+	n, err := p.Do(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		ctxErrs = append(ctxErrs, ctx.Err())
+		return ctx.Err()
+	}, nil)
+
+	// A timed out attempt is retried
+	if n != 2 {
+		t.Errorf("Do made %d attempts, want 2", n)
+	}
+	for _, ctxErr := range ctxErrs {
+		if !errors.Is(ctxErr, context.DeadlineExceeded) {
+			t.Errorf("attempt context error = %v, want DeadlineExceeded", ctxErr)
+		}
+	}
+	if err == nil || !strings.Contains(err.Error(), "timed out after 10ms") {
+		t.Errorf("Do = %v, want a timeout error", err)
+	}
+}
+
+func TestParentCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Policy{Timeout: time.Hour, Attempts: 3, Delay: time.Hour}
+	calls := 0
+	start := time.Now()
+	n, err := p.Do(ctx, func(ctx context.Context) error {
+		calls++
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}, nil)
+
+	// Canceling the parent is not a timeout and isn't retried
+	if n != 1 || calls != 1 {
+		t.Errorf("Do made %d attempts after the parent was canceled, want 1", calls)
+	}
+	if !errors.Is(err, context.Canceled) || strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Do = %v, want context.Canceled without a timeout", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Do waited for the next attempt after the parent was canceled")
+	}
+}
+
+func TestCanceledWhileWaiting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Policy{Attempts: 3, Delay: time.Hour}
+	n, err := p.Do(ctx, func(ctx context.Context) error {
+		return errFailed
+	}, func(err error, wait time.Duration) {
+		cancel()
+	})
+	if n != 1 || !errors.Is(err, errFailed) {
+		t.Errorf("Do = %d, %v, want to stop after the first attempt", n, err)
+	}
+}
+
+func TestMaxDuration(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		want   time.Duration
+	}{
+		{name: "no timeout", policy: Policy{Attempts: 3, Delay: time.Minute}},
+		{name: "one attempt", policy: Policy{Timeout: time.Hour}, want: time.Hour},
+		{
+			name:   "backoff capped",
+			policy: Policy{Timeout: time.Minute, Attempts: 4, Delay: time.Second, MaxDelay: 3 * time.Second},
+			want:   4*time.Minute + 6*time.Second,
+		},
+		{
+			name:   "jitter",
+			policy: Policy{Timeout: time.Minute, Attempts: 2, Delay: 10 * time.Second, Jitter: 0.5},
+			want:   2*time.Minute + 15*time.Second,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.MaxDuration(); got != tt.want {
+			t.Errorf("%s: MaxDuration = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, p := range []Policy{
+		{Timeout: -1},
+		{Attempts: -1},
+		{Jitter: 1.5},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validate(%+v) = nil, want an error", p)
+		}
+	}
+	if err := (Policy{Timeout: time.Second, Attempts: 3, Jitter: 0.2}).Validate(); err != nil {
+		t.Errorf("Validate = %v", err)
+	}
+}
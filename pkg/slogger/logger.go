@@ -5,11 +5,12 @@ import (
 	"os"
 )
 
+// SetupLogger writes JSON logs to stderr so that command output on stdout stays machine-readable
 func SetupLogger() *slog.Logger {
 	var log *slog.Logger
 
 	log = slog.New(
-		slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}),
 	)
 	return log
 }
@@ -11,7 +11,7 @@ import (
 )
 
 func InitS3Client(bucket, region, accessKey, secretKey, endpoint string) (*s3.Client, error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
+	cfg, err := config.LoadDefaultConfig(context.Background(),
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
 			accessKey, // Access Key
 			secretKey, // Secret Key
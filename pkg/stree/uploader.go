@@ -1,24 +1,25 @@
 package stree
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"mime"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/google/uuid"
 )
 
-// UploadFileToS3 uploads a local file to S3 and returns the URL
-func UploadFileToS3(stree *s3.Client, bucketName string, filePath string, folder string) (string, error) {
-	log.Println("🚀 Starting file upload to S3", "filePath", filePath, "folder", folder)
+// UploadFileToS3 uploads a local file to S3 under objectKey and returns the key.
+// metadata is stored as user-defined object metadata (x-amz-meta-*).
+func UploadFileToS3(ctx context.Context, stree *s3.Client, bucketName string, filePath string, objectKey string, metadata map[string]string) (string, error) {
+	log.Println("🚀 Starting file upload to S3", "filePath", filePath, "objectKey", objectKey)
 
 	// Open the file
 	file, err := os.Open(filePath)
@@ -39,16 +40,6 @@ func UploadFileToS3(stree *s3.Client, bucketName string, filePath string, folder
 
 	log.Println("📏 File size", "size", fileInfo.Size(), "filePath", filePath)
 
-	// Generate unique file name
-	uniqueFileName := fmt.Sprintf("%s_%s%s",
-		time.Now().Format("20060102150405"),
-		uuid.New().String(),
-		strings.ToLower(filepath.Ext(filePath)),
-	)
-	objectKey := fmt.Sprintf("%s/%s", folder, uniqueFileName)
-
-	log.Println("🔑 Generated unique S3 key", "objectKey", objectKey)
-
 	// Determine MIME type
 	contentType := mime.TypeByExtension(filepath.Ext(filePath))
 	if contentType == "" {
@@ -65,14 +56,14 @@ func UploadFileToS3(stree *s3.Client, bucketName string, filePath string, folder
 		Key:           aws.String(objectKey),
 		Body:          file,
 		ContentType:   aws.String(contentType),
-		ACL:           types.ObjectCannedACLPublicRead, // Make the file public
 		ContentLength: aws.Int64(fileInfo.Size()),
+		Metadata:      metadata,
 	}
 
 	log.Println("☁️ Sending file to S3", "bucket", bucketName, "objectKey", objectKey)
 
 	// Upload file to S3
-	_, err = stree.PutObject(context.TODO(), input)
+	_, err = stree.PutObject(ctx, input)
 	if err != nil {
 		log.Println("❌ Error uploading file to S3", "bucket", bucketName, "objectKey", objectKey, "error", err)
 		return "", fmt.Errorf("error uploading file to S3: %w", err)
@@ -83,6 +74,19 @@ func UploadFileToS3(stree *s3.Client, bucketName string, filePath string, folder
 	return objectKey, nil
 }
 
+// PresignDownloadURL returns a link that downloads the object without credentials until it expires
+func PresignDownloadURL(ctx context.Context, stree *s3.Client, bucketName string, objectKey string, ttl time.Duration) (string, error) {
+	presigner := s3.NewPresignClient(stree)
+	req, err := presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("error presigning download of %s: %w", objectKey, err)
+	}
+	return req.URL, nil
+}
+
 // convertMapToAWSMetadata converts map[string]string to map[string]*string
 func convertMapToAWSMetadata(metadata map[string]string) map[string]*string {
 	converted := make(map[string]*string)
@@ -93,11 +97,8 @@ func convertMapToAWSMetadata(metadata map[string]string) map[string]*string {
 	return converted
 }
 
-// ListFilesInS3Directory gets a list of files from the specified directory in S3
-func ListFilesInS3Directory(stree *s3.Client, bucketName string, folder string) ([]string, error) {
-
-	// Form prefix for file search (directory + "/")
-	prefix := fmt.Sprintf("%s/", folder)
+// ListFilesInS3 gets the keys of all objects starting with prefix
+func ListFilesInS3(ctx context.Context, stree *s3.Client, bucketName string, prefix string) ([]string, error) {
 
 	// Request to get list of objects with specified prefix
 	input := &s3.ListObjectsV2Input{
@@ -105,23 +106,161 @@ func ListFilesInS3Directory(stree *s3.Client, bucketName string, folder string)
 		Prefix: aws.String(prefix),
 	}
 
-	// Execute request to S3
-	output, err := stree.ListObjectsV2(context.TODO(), input)
+	// Walk through all pages, a single response holds at most 1000 keys
+	var files []string
+	paginator := s3.NewListObjectsV2Paginator(stree, input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error getting list of files from S3: %w", err)
+		}
+		for _, item := range output.Contents {
+			files = append(files, *item.Key)
+		}
+	}
+
+	return files, nil
+}
+
+// ObjectInfo holds the attributes of an S3 object returned by HeadFileInS3
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// HeadFileInS3 reads the size, modification time and user metadata of an object
+func HeadFileInS3(ctx context.Context, stree *s3.Client, bucketName string, objectKey string) (*ObjectInfo, error) {
+	output, err := stree.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error getting list of files from S3: %w", err)
+		return nil, fmt.Errorf("error reading metadata of %s from S3: %w", objectKey, err)
 	}
 
-	// Form list of file names
-	var files []string
-	for _, item := range output.Contents {
-		files = append(files, *item.Key)
+	info := &ObjectInfo{
+		Key:      objectKey,
+		Size:     aws.ToInt64(output.ContentLength),
+		Metadata: output.Metadata,
 	}
+	if output.LastModified != nil {
+		info.LastModified = *output.LastModified
+	}
+	return info, nil
+}
 
-	return files, nil
+// GetObjectTags returns the tags of an object
+func GetObjectTags(ctx context.Context, stree *s3.Client, bucketName string, objectKey string) (map[string]string, error) {
+	output, err := stree.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading tags of %s from S3: %w", objectKey, err)
+	}
+	tags := make(map[string]string, len(output.TagSet))
+	for _, tag := range output.TagSet {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tags, nil
+}
+
+// PutObjectTags replaces the tags of an object, no tags delete the tag set
+func PutObjectTags(ctx context.Context, stree *s3.Client, bucketName string, objectKey string, tags map[string]string) error {
+	var err error
+	if len(tags) == 0 {
+		_, err = stree.DeleteObjectTagging(ctx, &s3.DeleteObjectTaggingInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(objectKey),
+		})
+	} else {
+		tagging := &types.Tagging{}
+		for key, value := range tags {
+			tagging.TagSet = append(tagging.TagSet, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+		_, err = stree.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+			Bucket:  aws.String(bucketName),
+			Key:     aws.String(objectKey),
+			Tagging: tagging,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("error writing tags of %s to S3: %w", objectKey, err)
+	}
+	return nil
+}
+
+// DownloadFileFromS3 streams an object into a local file
+func DownloadFileFromS3(ctx context.Context, stree *s3.Client, bucketName string, objectKey string, filePath string) error {
+	output, err := stree.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return fmt.Errorf("error downloading file %s from S3: %w", objectKey, err)
+	}
+	defer output.Body.Close()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, output.Body); err != nil {
+		return fmt.Errorf("error downloading file %s from S3: %w", objectKey, err)
+	}
+	return file.Close()
+}
+
+// OpenFileFromS3 opens an object for streaming and returns its size, the caller closes the reader
+func OpenFileFromS3(ctx context.Context, stree *s3.Client, bucketName string, objectKey string) (io.ReadCloser, int64, error) {
+	output, err := stree.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("error downloading file %s from S3: %w", objectKey, err)
+	}
+	return output.Body, aws.ToInt64(output.ContentLength), nil
+}
+
+// ReadFileFromS3 downloads a small object, such as a manifest, into memory
+func ReadFileFromS3(ctx context.Context, stree *s3.Client, bucketName string, objectKey string) ([]byte, error) {
+	output, err := stree.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading file %s from S3: %w", objectKey, err)
+	}
+	defer output.Body.Close()
+
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file %s from S3: %w", objectKey, err)
+	}
+	return data, nil
+}
+
+// WriteFileToS3 uploads a small object from memory
+func WriteFileToS3(ctx context.Context, stree *s3.Client, bucketName string, objectKey string, data []byte) error {
+	_, err := stree.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(bucketName),
+		Key:           aws.String(objectKey),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return fmt.Errorf("error writing file %s to S3: %w", objectKey, err)
+	}
+	return nil
 }
 
 // DeleteFileFromS3 deletes a file from S3 by its path (objectKey)
-func DeleteFileFromS3(stree *s3.Client, bucketName string, filePath string) error {
+func DeleteFileFromS3(ctx context.Context, stree *s3.Client, bucketName string, filePath string) error {
 
 	// Form delete request
 	input := &s3.DeleteObjectInput{
@@ -130,7 +269,7 @@ func DeleteFileFromS3(stree *s3.Client, bucketName string, filePath string) erro
 	}
 
 	// Execute deletion
-	_, err := stree.DeleteObject(context.TODO(), input)
+	_, err := stree.DeleteObject(ctx, input)
 	if err != nil {
 		return fmt.Errorf("error deleting file %s from S3: %w", filePath, err)
 	}